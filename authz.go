@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+type contextKey int
+
+const principalContextKey contextKey = iota
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	User   database.User
+	Claims auth.Claims
+}
+
+// scopesForRole returns the scopes granted to access tokens of users with
+// the given role. Users stored before roles existed have no role and are
+// treated as RoleUser.
+func scopesForRole(role string) []string {
+	scopes := []string{auth.ScopeChirpsWrite, auth.ScopeUsersWrite, auth.ScopeMessagesRead, auth.ScopeMessagesWrite}
+	switch role {
+	case database.RoleModerator:
+		scopes = append(scopes, auth.ScopeChirpsModerate)
+	case database.RoleAdmin:
+		scopes = append(scopes, auth.ScopeChirpsModerate, auth.ScopeAdminAll)
+	}
+	return scopes
+}
+
+// errAuthUnavailable wraps errors that kept authenticate from checking the
+// token at all, as opposed to the token being invalid.
+var errAuthUnavailable = errors.New("couldn't authenticate")
+
+// authenticate validates the bearer token of r and loads its user. Storage
+// failures are wrapped in errAuthUnavailable.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return principal{}, err
+	}
+
+	claims, err := auth.ParseJWT(token, cfg.jwtKeys, cfg.DB)
+	if errors.Is(err, auth.ErrRevocationCheck) {
+		return principal{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+	}
+	if err != nil {
+		return principal{}, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return principal{}, err
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		return principal{}, fmt.Errorf("%w: %w", errAuthUnavailable, err)
+	}
+	if err != nil {
+		return principal{}, err
+	}
+	if user.DeletedAt != nil {
+		return principal{}, errors.New("account is pending deletion")
+	}
+
+	return principal{
+		User:   user,
+		Claims: claims,
+	}, nil
+}
+
+// middlewareAuthenticate requires a valid access token before calling next.
+// The caller is available to next through principalFromContext.
+func (cfg *apiConfig) middlewareAuthenticate(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareAuthorize("", next)
+}
+
+// middlewareAuthorize requires a valid access token granting scope before
+// calling next. An empty scope only requires authentication.
+func (cfg *apiConfig) middlewareAuthorize(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+
+		if scope != "" && !p.Claims.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope=%q`, scope))
+			respondWithError(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+	}
+}
+
+// middlewareOptionalAuth authenticates the caller when a bearer token is
+// sent and otherwise lets the request through anonymously. A token that is
+// sent but invalid is still rejected.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+			next(w, r)
+			return
+		}
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+	}
+}
+
+// middlewareRequireRole requires the authenticated caller to currently hold
+// role. It must be wrapped by middlewareAuthorize.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFromContext(r.Context())
+		if p.User.Role != role {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="insufficient_scope"`)
+			respondWithError(w, http.StatusForbidden, "Requires the "+role+" role")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// respondUnauthorized writes a 401 with a WWW-Authenticate challenge as
+// described in RFC 6750, or a 500 if err is errAuthUnavailable.
+func respondUnauthorized(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAuthUnavailable) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate")
+		return
+	}
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+		respondWithError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="invalid_token"`)
+	respondWithError(w, http.StatusUnauthorized, "Invalid token")
+}
+
+// principalFromContext returns the caller stored by the auth middleware and
+// whether there is one.
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(principal)
+	return p, ok
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	// defaultExportDir is used unless EXPORT_DIR is set. Archives are only
+	// handed out through signed download URLs; /app doesn't serve this
+	// directory even though it lies in the working directory.
+	defaultExportDir       = "exports"
+	defaultExportRetention = 7 * 24 * time.Hour
+)
+
+// exportProfile is the account data included in an export, leaving out
+// credentials.
+type exportProfile struct {
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	PendingEmail  string `json:"pending_email,omitempty"`
+	Handle        string `json:"handle"`
+	DisplayName   string `json:"display_name,omitempty"`
+	Bio           string `json:"bio,omitempty"`
+	Website       string `json:"website,omitempty"`
+	Protected     bool   `json:"protected"`
+	EmailVerified bool   `json:"email_verified"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Role          string `json:"role"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+}
+
+// queueExport wakes the export worker. Exports are queued in the database,
+// so a wake-up that is dropped because the worker is busy loses nothing.
+func (cfg *apiConfig) queueExport() {
+	select {
+	case cfg.exportWake <- struct{}{}:
+	default:
+	}
+}
+
+// runExportWorker builds pending exports, including any left over from a
+// previous run, until ctx is cancelled.
+func (cfg *apiConfig) runExportWorker(ctx context.Context) {
+	for {
+		cfg.buildPendingExports(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.exportWake:
+		}
+	}
+}
+
+func (cfg *apiConfig) buildPendingExports(ctx context.Context) {
+	exports, err := cfg.DB.GetPendingExports()
+	if err != nil {
+		log.Printf("Exports: couldn't list pending exports: %s", err)
+		return
+	}
+
+	for _, export := range exports {
+		if ctx.Err() != nil {
+			return
+		}
+		file, err := cfg.buildExport(export)
+		if err != nil {
+			log.Printf("Exports: couldn't build export %s for user %d: %s", export.ID, export.UserID, err)
+		}
+		err = cfg.DB.CompleteExport(export.ID, file, err != nil, time.Now().Add(cfg.exportRetention).UTC())
+		if err != nil {
+			log.Printf("Exports: couldn't save export %s: %s", export.ID, err)
+		}
+	}
+}
+
+// buildExport writes the user's data to a ZIP of JSON files in the export
+// directory and returns its name.
+func (cfg *apiConfig) buildExport(export database.Export) (string, error) {
+	user, err := cfg.DB.GetUser(export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	allChirps, err := cfg.DB.GetChirps()
+	if err != nil {
+		return "", err
+	}
+	chirps := []database.Chirp{}
+	for _, chirp := range allChirps {
+		if chirp.AuthorID != user.ID {
+			continue
+		}
+		// Who voted for what in the user's polls belongs to the voters
+		if chirp.Poll != nil {
+			poll := *chirp.Poll
+			poll.Votes = nil
+			chirp.Poll = &poll
+		}
+		chirps = append(chirps, chirp)
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID < chirps[j].ID
+	})
+
+	sessions, err := cfg.DB.GetSessions(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	blocks, err := cfg.DB.GetBlocks(user.ID)
+	if err != nil {
+		return "", err
+	}
+	mutes, err := cfg.DB.GetMutes(user.ID)
+	if err != nil {
+		return "", err
+	}
+	followers, err := cfg.DB.GetFollowers(user.ID)
+	if err != nil {
+		return "", err
+	}
+	following, err := cfg.DB.GetFollowing(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	type exportConversation struct {
+		database.Conversation
+		Messages []database.Message `json:"messages"`
+	}
+	conversations := []exportConversation{}
+	summaries, err := cfg.DB.GetConversations(user.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, summary := range summaries {
+		messages, err := cfg.DB.GetMessages(summary.ID, user.ID, 0, math.MaxInt)
+		if err != nil {
+			return "", err
+		}
+		conversations = append(conversations, exportConversation{
+			Conversation: summary.Conversation,
+			Messages:     messages,
+		})
+	}
+
+	notifications, err := cfg.DB.GetNotifications(user.ID, 0, math.MaxInt, false)
+	if err != nil {
+		return "", err
+	}
+
+	drafts, err := cfg.DB.GetDrafts(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	votes, err := cfg.DB.GetPollVotes(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", exportProfile{
+			ID:            user.ID,
+			Email:         user.Email,
+			PendingEmail:  user.PendingEmail,
+			Handle:        user.Handle,
+			DisplayName:   user.DisplayName,
+			Bio:           user.Bio,
+			Website:       user.Website,
+			Protected:     user.Protected,
+			EmailVerified: user.EmailVerified,
+			IsChirpyRed:   user.IsChirpyRed,
+			Role:          user.Role,
+			TOTPEnabled:   user.TOTPEnabled,
+		}},
+		{"chirps.json", chirps},
+		{"sessions.json", sessions},
+		{"blocks.json", blocks},
+		{"mutes.json", mutes},
+		{"followers.json", followers},
+		{"following.json", following},
+		{"conversations.json", conversations},
+		{"notifications.json", notifications},
+		{"drafts.json", drafts},
+		{"poll_votes.json", votes},
+	}
+
+	err = os.MkdirAll(cfg.exportDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	name := export.ID + ".zip"
+	tmpPath := filepath.Join(cfg.exportDir, name+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	archive := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return name, os.Rename(tmpPath, filepath.Join(cfg.exportDir, name))
+}
+
+// purgeExpiredExports deletes exports past their retention along with their
+// archives.
+func (cfg *apiConfig) purgeExpiredExports(now time.Time) int {
+	exports, err := cfg.DB.PurgeExpiredExports(now)
+	if err != nil {
+		log.Printf("Janitor: couldn't purge expired exports: %s", err)
+		return 0
+	}
+	for _, export := range exports {
+		if export.File == "" {
+			continue
+		}
+		err := os.Remove(filepath.Join(cfg.exportDir, export.File))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Janitor: couldn't remove export archive %s: %s", export.File, err)
+		}
+	}
+	return len(exports)
+}
@@ -9,4 +9,5 @@ require (
 
 require (
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/sys v0.22.0 // indirect
 )
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+func (cfg *apiConfig) handlerAdminLockoutsList(w http.ResponseWriter, r *http.Request) {
+	attempts, err := cfg.DB.GetLoginAttempts()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve login attempts")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, attempts)
+}
+
+// handlerAdminLockoutsClear forgets the failed logins for a key such as
+// "email:someone@example.com" or "ip:203.0.113.7", lifting any lockout.
+func (cfg *apiConfig) handlerAdminLockoutsClear(w http.ResponseWriter, r *http.Request) {
+	err := cfg.DB.ClearLoginAttempts(r.PathValue("key"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "No login attempts recorded for key")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't clear login attempts")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
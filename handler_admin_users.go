@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+type adminUser struct {
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	Handle      string `json:"handle"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	dbUsers, err := cfg.DB.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users")
+		return
+	}
+
+	users := []adminUser{}
+	for _, dbUser := range dbUsers {
+		users = append(users, adminUser{
+			ID:          dbUser.ID,
+			Email:       dbUser.Email,
+			Handle:      dbUser.Handle,
+			IsChirpyRed: dbUser.IsChirpyRed,
+			Role:        dbUser.Role,
+		})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) handlerAdminUsersSetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.SetUserRole(userID, params.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidRole) {
+			respondWithError(w, http.StatusBadRequest, "Invalid role")
+		} else if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update role")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, adminUser{
+		ID:          user.ID,
+		Email:       user.Email,
+		Handle:      user.Handle,
+		IsChirpyRed: user.IsChirpyRed,
+		Role:        user.Role,
+	})
+}
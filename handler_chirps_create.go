@@ -3,68 +3,263 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
 )
 
 type Chirp struct {
-	ID   int    `json:"id"`
+	ID          int             `json:"id"`
+	Body        string          `json:"body"`
+	AuthorID    int             `json:"author_id"`
+	Author      *authorSummary  `json:"author"`
+	Attachments []mediaResponse `json:"attachments,omitempty"`
+	Visibility  string          `json:"visibility"`
+	MentionIDs  []int           `json:"mention_ids,omitempty"`
+	PublishAt   *time.Time      `json:"publish_at,omitempty"`
+	Scheduled   bool            `json:"scheduled,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+	Poll        *pollResponse   `json:"poll,omitempty"`
+}
+
+const (
+	// maxScheduleAhead is how far in the future a chirp can be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// minChirpTTL is the shortest time a self-expiring chirp can last.
+	minChirpTTL = time.Minute
+)
+
+// chirpInput is the content of a chirp as sent by its author, either
+// directly or saved as a draft.
+type chirpInput struct {
 	Body string `json:"body"`
+	// AttachmentIDs are images the caller uploaded to POST /api/media.
+	AttachmentIDs []string `json:"attachment_ids"`
+	// Visibility defaults to public.
+	Visibility string `json:"visibility"`
+	// PublishAt schedules the chirp instead of publishing it right away.
+	PublishAt *time.Time `json:"publish_at"`
+	// TTL, a duration such as "24h", or ExpiresAt make the chirp delete
+	// itself. TTL counts from when the chirp is published.
+	TTL       string     `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Poll      *pollInput `json:"poll"`
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Body string `json:"body"`
+	caller, _ := principalFromContext(r.Context())
+	if cfg.requireVerifiedEmail && !caller.User.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before posting")
+		return
 	}
 
-	// Extract the token from the request headers using your auth package.
-	token, err := auth.GetBearerToken(r.Header)
+	// Decode the request body.
+	decoder := json.NewDecoder(r.Body)
+	params := chirpInput{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	// Validate the token and extract the user ID using your auth package.
-	userIDStr, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	cleaned, chirpParams, ok := cfg.prepareChirp(w, caller.User.ID, params)
+	if !ok {
 		return
 	}
 
-	// Convert userID from string to integer
-	userID, err := strconv.Atoi(userIDStr)
+	// Create the chirp with the author_id.
+	chirp, err := cfg.DB.CreateChirp(cleaned, caller.User.ID, chirpParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Invalid user ID")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	// Decode the request body.
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	cfg.respondWithNewChirp(w, caller.User.ID, chirp)
+}
+
+// prepareChirp validates a chirp and works out what to store. On failure
+// it responds to the client and returns false.
+func (cfg *apiConfig) prepareChirp(w http.ResponseWriter, authorID int, params chirpInput) (string, database.ChirpParams, bool) {
+	cleaned, err := validateChirp(params.Body)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
-		return
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return "", database.ChirpParams{}, false
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPublic
+	}
+	if !database.ValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, followers, unlisted or direct")
+		return "", database.ChirpParams{}, false
+	}
+
+	if params.PublishAt != nil {
+		publishAt := params.PublishAt.UTC()
+		params.PublishAt = &publishAt
+		if !publishAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "publish_at must be in the future")
+			return "", database.ChirpParams{}, false
+		}
+		if publishAt.After(time.Now().Add(maxScheduleAhead)) {
+			respondWithError(w, http.StatusBadRequest, "Chirps can be scheduled at most a year ahead")
+			return "", database.ChirpParams{}, false
+		}
+	}
+
+	expiresAt, err := params.expiry()
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
+		return "", database.ChirpParams{}, false
 	}
 
-	// Create the chirp with the author_id.
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	var poll *database.Poll
+	if params.Poll != nil {
+		publishAt := time.Now()
+		if params.PublishAt != nil {
+			publishAt = *params.PublishAt
+		}
+		poll, err = params.Poll.validate(publishAt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return "", database.ChirpParams{}, false
+		}
+	}
+
+	mentionIDs, err := cfg.resolveMentions(cleaned, authorID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve mentions")
+		return "", database.ChirpParams{}, false
+	}
+	if params.Visibility == database.VisibilityDirect && len(mentionIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Direct chirps must mention at least one user")
+		return "", database.ChirpParams{}, false
+	}
+
+	attachments, ok := cfg.ownedAttachments(w, params.AttachmentIDs, authorID)
+	if !ok {
+		return "", database.ChirpParams{}, false
+	}
+
+	return cleaned, database.ChirpParams{
+		Attachments: attachments,
+		Visibility:  params.Visibility,
+		MentionIDs:  mentionIDs,
+		PublishAt:   params.PublishAt,
+		ExpiresAt:   expiresAt,
+		Poll:        poll,
+	}, true
+}
+
+// expiry works out when a chirp published at params.PublishAt, or now,
+// expires. It returns nil for chirps that don't expire.
+func (params chirpInput) expiry() (*time.Time, error) {
+	if params.TTL == "" && params.ExpiresAt == nil {
+		return nil, nil
+	}
+	if params.TTL != "" && params.ExpiresAt != nil {
+		return nil, errors.New("Set either ttl or expires_at, not both")
+	}
+
+	publishAt := time.Now().UTC()
+	if params.PublishAt != nil {
+		publishAt = *params.PublishAt
+	}
+
+	if params.ExpiresAt != nil {
+		expiresAt := params.ExpiresAt.UTC()
+		if expiresAt.Before(publishAt.Add(minChirpTTL)) {
+			return nil, fmt.Errorf("expires_at must be at least %s after the chirp is published", minChirpTTL)
+		}
+		return &expiresAt, nil
+	}
+
+	ttl, err := time.ParseDuration(params.TTL)
+	if err != nil {
+		return nil, errors.New("ttl must be a duration such as 90m or 24h")
+	}
+	if ttl < minChirpTTL {
+		return nil, fmt.Errorf("ttl must be at least %s", minChirpTTL)
+	}
+	expiresAt := publishAt.Add(ttl)
+	return &expiresAt, nil
+}
+
+// ownedAttachments looks up images to attach to a chirp, which must have
+// been uploaded by userID. On failure it responds to the client and
+// returns false.
+func (cfg *apiConfig) ownedAttachments(w http.ResponseWriter, ids []string, userID int) ([]database.MediaRef, bool) {
+	if len(ids) > maxChirpAttachments {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A chirp can have at most %d attachments", maxChirpAttachments))
+		return nil, false
+	}
+	attachments, err := cfg.DB.GetOwnedMedia(ids, userID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusBadRequest, "Unknown attachment")
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve attachments")
+		return nil, false
+	}
+	return attachments, true
+}
+
+// respondWithNewChirp responds with a chirp that was just created, waking
+// the scheduler if it was scheduled.
+func (cfg *apiConfig) respondWithNewChirp(w http.ResponseWriter, authorID int, chirp database.Chirp) {
+	if chirp.Scheduled {
+		cfg.wakeScheduler()
+	}
+
+	chirps, err := cfg.chirpResponses(authorID, []database.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve author")
 		return
 	}
 
-	// Include the author_id in the response using the database `Chirp` struct.
-	respondWithJSON(w, http.StatusCreated, chirp)
+	respondWithJSON(w, http.StatusCreated, chirps[0])
+}
+
+// mentionPattern matches @handle mentions in a chirp body.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w{3,15})\b`)
+
+// resolveMentions returns the IDs of the users mentioned in body. Users who
+// blocked the author or were blocked by them can't be mentioned and are
+// left out, as is the author.
+func (cfg *apiConfig) resolveMentions(body string, authorID int) ([]int, error) {
+	handles := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handles = append(handles, database.NormalizeHandle(match[1]))
+	}
+	if len(handles) == 0 {
+		return nil, nil
+	}
+
+	users, err := cfg.DB.GetUsersByHandles(handles)
+	if err != nil {
+		return nil, err
+	}
+	mentionIDs := []int{}
+	for _, user := range users {
+		if user.ID == authorID {
+			continue
+		}
+		blocked, err := cfg.DB.IsBlocked(authorID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !blocked {
+			mentionIDs = append(mentionIDs, user.ID)
+		}
+	}
+	sort.Ints(mentionIDs)
+	return mentionIDs, nil
 }
 
 func validateChirp(body string) (string, error) {
@@ -16,54 +16,44 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	caller, _ := principalFromContext(r.Context())
+
+	dbChirp, err := cfg.DB.GetChirpForViewer(chirpID, caller.User.ID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:   dbChirp.ID,
-		Body: dbChirp.Body,
-	})
+	chirps, err := cfg.chirpResponses(caller.User.ID, []database.Chirp{dbChirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve author")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps[0])
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
 	s := r.URL.Query().Get("author_id")
 	srt := r.URL.Query().Get("sort")
 
-	dbChirps, err := cfg.DB.GetChirps()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
-		return
-	}
-
-	chirps := []database.Chirp{}
-
-	// Collect all chirps first
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, database.Chirp{
-			ID:       dbChirp.ID,
-			AuthorID: dbChirp.AuthorID,
-			Body:     dbChirp.Body,
-		})
-	}
+	caller, _ := principalFromContext(r.Context())
 
 	// Filter based on author_id if provided
+	authorID := 0
 	if s != "" {
-		authorID, err := strconv.Atoi(s)
+		var err error
+		authorID, err = strconv.Atoi(s)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
 			return
 		}
-		// Filter after collecting all chirps
-		filteredChirps := []database.Chirp{}
-		for _, chirp := range chirps {
-			if chirp.AuthorID == authorID {
-				filteredChirps = append(filteredChirps, chirp)
-			}
-		}
-		chirps = filteredChirps
+	}
+
+	chirps, err := cfg.DB.GetChirpsForViewer(caller.User.ID, authorID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
 	}
 
 	// Sort based on the sort parameter
@@ -78,6 +68,12 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 		})
 	}
 
+	resp, err := cfg.chirpResponses(caller.User.ID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve authors")
+		return
+	}
+
 	// Respond with sorted chirps
-	respondWithJSON(w, http.StatusOK, chirps)
+	respondWithJSON(w, http.StatusOK, resp)
 }
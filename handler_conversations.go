@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	maxConversationParticipants = 20
+	maxMessageLength            = 2000
+	defaultMessagePageSize      = 50
+	maxMessagePageSize          = 100
+)
+
+type conversationResponse struct {
+	ID             int                          `json:"id"`
+	ParticipantIDs []int                        `json:"participant_ids"`
+	CreatedAt      time.Time                    `json:"created_at"`
+	LastMessageAt  time.Time                    `json:"last_message_at"`
+	Reads          map[int]database.ReadReceipt `json:"reads"`
+	LastMessage    *database.Message            `json:"last_message,omitempty"`
+	UnreadCount    int                          `json:"unread_count"`
+}
+
+// handlerConversationsCreate starts a conversation between the caller and
+// participant_ids, or returns the one they already have.
+func (cfg *apiConfig) handlerConversationsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ParticipantIDs []int `json:"participant_ids"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	participantIDs := append(params.ParticipantIDs, caller.User.ID)
+	slices.Sort(participantIDs)
+	participantIDs = slices.Compact(participantIDs)
+	if len(participantIDs) < 2 {
+		respondWithError(w, http.StatusBadRequest, "A conversation needs at least one other participant")
+		return
+	}
+	if len(participantIDs) > maxConversationParticipants {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A conversation can have at most %d participants", maxConversationParticipants))
+		return
+	}
+
+	conversation, created, err := cfg.DB.CreateConversation(participantIDs)
+	if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create conversation")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, conversationResponse{
+		ID:             conversation.ID,
+		ParticipantIDs: conversation.ParticipantIDs,
+		CreatedAt:      conversation.CreatedAt,
+		LastMessageAt:  conversation.LastMessageAt,
+		Reads:          conversation.Reads,
+	})
+}
+
+// handlerConversationsList lists the caller's conversations, most recently
+// active first, with their unread counts.
+func (cfg *apiConfig) handlerConversationsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	summaries, err := cfg.DB.GetConversations(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve conversations")
+		return
+	}
+
+	resp := []conversationResponse{}
+	for _, summary := range summaries {
+		resp = append(resp, conversationResponse{
+			ID:             summary.ID,
+			ParticipantIDs: summary.ParticipantIDs,
+			CreatedAt:      summary.CreatedAt,
+			LastMessageAt:  summary.LastMessageAt,
+			Reads:          summary.Reads,
+			LastMessage:    summary.LastMessage,
+			UnreadCount:    summary.UnreadCount,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerMessagesCreate sends a message to a conversation.
+func (cfg *apiConfig) handlerMessagesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	body := strings.TrimSpace(params.Body)
+	if body == "" {
+		respondWithError(w, http.StatusBadRequest, "Message is empty")
+		return
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Message must be at most %d characters", maxMessageLength))
+		return
+	}
+
+	message, err := cfg.DB.CreateMessage(conversationID, caller.User.ID, body)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You can't message this conversation")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, message)
+}
+
+// handlerMessagesList pages through a conversation's history, newest
+// first. Pass the smallest ID seen as ?before= to get the next page.
+func (cfg *apiConfig) handlerMessagesList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	before := 0
+	if s := r.URL.Query().Get("before"); s != "" {
+		before, err = strconv.Atoi(s)
+		if err != nil || before < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
+	}
+	limit := defaultMessagePageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxMessagePageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxMessagePageSize))
+			return
+		}
+	}
+
+	messages, err := cfg.DB.GetMessages(conversationID, caller.User.ID, before, limit)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve messages")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, messages)
+}
+
+// handlerConversationsRead marks the conversation read up to message_id,
+// or entirely if it's left out, and returns the caller's read receipt.
+func (cfg *apiConfig) handlerConversationsRead(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MessageID int `json:"message_id"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+			return
+		}
+	}
+
+	receipt, err := cfg.DB.MarkConversationRead(conversationID, caller.User.ID, params.MessageID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark conversation read")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, receipt)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+type draftResponse struct {
+	ID          int             `json:"id"`
+	Body        string          `json:"body"`
+	Attachments []mediaResponse `json:"attachments,omitempty"`
+	Visibility  string          `json:"visibility,omitempty"`
+	PublishAt   *time.Time      `json:"publish_at,omitempty"`
+	TTL         string          `json:"ttl,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+	Poll        *pollInput      `json:"poll,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func (cfg *apiConfig) draftResponse(draft database.Draft) draftResponse {
+	resp := draftResponse{
+		ID:         draft.ID,
+		Body:       draft.Body,
+		Visibility: draft.Visibility,
+		PublishAt:  draft.PublishAt,
+		TTL:        draft.TTL,
+		ExpiresAt:  draft.ExpiresAt,
+		Poll:       draftPoll(draft),
+		CreatedAt:  draft.CreatedAt,
+		UpdatedAt:  draft.UpdatedAt,
+	}
+	for _, attachment := range draft.Attachments {
+		resp.Attachments = append(resp.Attachments, cfg.restrictedMediaResponse(attachment, draft.AuthorID))
+	}
+	return resp
+}
+
+// draftPoll returns the draft's poll as it was entered.
+func draftPoll(draft database.Draft) *pollInput {
+	if draft.Poll == nil {
+		return nil
+	}
+	poll := &pollInput{Options: draft.Poll.Options}
+	if !draft.Poll.ClosesAt.IsZero() {
+		closesAt := draft.Poll.ClosesAt
+		poll.ClosesAt = &closesAt
+	}
+	return poll
+}
+
+// prepareDraft checks the parts of a draft that can't wait until it's
+// published. Drafts may be incomplete, so the rest is checked then. On
+// failure it responds to the client and returns false.
+func (cfg *apiConfig) prepareDraft(w http.ResponseWriter, authorID int, params chirpInput) (database.DraftParams, bool) {
+	_, err := validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return database.DraftParams{}, false
+	}
+	if params.Visibility != "" && !database.ValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, followers, unlisted or direct")
+		return database.DraftParams{}, false
+	}
+	attachments, ok := cfg.ownedAttachments(w, params.AttachmentIDs, authorID)
+	if !ok {
+		return database.DraftParams{}, false
+	}
+	if params.TTL != "" {
+		_, err = time.ParseDuration(params.TTL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "ttl must be a duration such as 90m or 24h")
+			return database.DraftParams{}, false
+		}
+	}
+	if params.PublishAt != nil {
+		publishAt := params.PublishAt.UTC()
+		params.PublishAt = &publishAt
+	}
+	if params.ExpiresAt != nil {
+		expiresAt := params.ExpiresAt.UTC()
+		params.ExpiresAt = &expiresAt
+	}
+	var poll *database.Poll
+	if params.Poll != nil {
+		if len(params.Poll.Options) > maxPollOptions {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A poll can have at most %d options", maxPollOptions))
+			return database.DraftParams{}, false
+		}
+		poll = &database.Poll{Options: params.Poll.Options}
+		if params.Poll.ClosesAt != nil {
+			poll.ClosesAt = params.Poll.ClosesAt.UTC()
+		}
+	}
+
+	return database.DraftParams{
+		Body:        params.Body,
+		Attachments: attachments,
+		Visibility:  params.Visibility,
+		PublishAt:   params.PublishAt,
+		TTL:         params.TTL,
+		ExpiresAt:   params.ExpiresAt,
+		Poll:        poll,
+	}, true
+}
+
+func (cfg *apiConfig) handlerDraftsCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := chirpInput{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	draftParams, ok := cfg.prepareDraft(w, caller.User.ID, params)
+	if !ok {
+		return
+	}
+
+	draft, err := cfg.DB.CreateDraft(caller.User.ID, draftParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.draftResponse(draft))
+}
+
+func (cfg *apiConfig) handlerDraftsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	drafts, err := cfg.DB.GetDrafts(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve drafts")
+		return
+	}
+
+	resp := make([]draftResponse, 0, len(drafts))
+	for _, draft := range drafts {
+		resp = append(resp, cfg.draftResponse(draft))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDraftsGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	draft, err := cfg.DB.GetDraft(draftID, caller.User.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.draftResponse(draft))
+}
+
+// handlerDraftsUpdate replaces the content of a draft.
+func (cfg *apiConfig) handlerDraftsUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := chirpInput{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	draftParams, ok := cfg.prepareDraft(w, caller.User.ID, params)
+	if !ok {
+		return
+	}
+
+	draft, err := cfg.DB.UpdateDraft(draftID, caller.User.ID, draftParams)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.draftResponse(draft))
+}
+
+func (cfg *apiConfig) handlerDraftsDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	err = cfg.DB.DeleteDraft(draftID, caller.User.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete draft")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerDraftsPublish turns a draft into a chirp, scheduled if the draft
+// has a publish_at, and deletes the draft.
+func (cfg *apiConfig) handlerDraftsPublish(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	if cfg.requireVerifiedEmail && !caller.User.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before posting")
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	draft, err := cfg.DB.GetDraft(draftID, caller.User.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve draft")
+		return
+	}
+
+	input := chirpInput{
+		Body:       draft.Body,
+		Visibility: draft.Visibility,
+		PublishAt:  draft.PublishAt,
+		TTL:        draft.TTL,
+		ExpiresAt:  draft.ExpiresAt,
+		Poll:       draftPoll(draft),
+	}
+	for _, attachment := range draft.Attachments {
+		input.AttachmentIDs = append(input.AttachmentIDs, attachment.ID)
+	}
+	cleaned, chirpParams, ok := cfg.prepareChirp(w, caller.User.ID, input)
+	if !ok {
+		return
+	}
+
+	chirp, err := cfg.DB.PublishDraft(draftID, caller.User.ID, draft.UpdatedAt, cleaned, chirpParams)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return
+	}
+	if errors.Is(err, database.ErrDraftChanged) {
+		respondWithError(w, http.StatusConflict, "Draft was edited while publishing; try again")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't publish draft")
+		return
+	}
+
+	cfg.respondWithNewChirp(w, caller.User.ID, chirp)
+}
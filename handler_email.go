@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+	"github.com/TedMartell/ChirpyServerProject/internal/mailer"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// sendVerificationEmail mails user a link to verify their current address.
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeVerifyEmail, user.Email, cfg.jwtKeys, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Confirm this is your email address by opening the link below:\n\n%s/app/verify-email/?token=%s\n\nThe link expires in 24 hours.",
+			cfg.baseURL,
+			token,
+		),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmailRequest(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	if caller.User.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	err := cfg.sendVerificationEmail(caller.User)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	token, err := auth.ParsePurposeJWT(params.Token, auth.PurposeVerifyEmail, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	err = cfg.DB.ConsumeToken(token.TokenID, token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenUsed) {
+			respondWithError(w, http.StatusBadRequest, "Token has already been used")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't use token")
+		}
+		return
+	}
+
+	err = cfg.DB.VerifyEmail(token.UserID, token.Email)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Email address has changed since the token was sent")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPasswordResetRequest always responds 202 so it can't be used to
+// find out which emails have accounts.
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	if err == nil {
+		token, err := auth.MakePasswordResetJWT(user.ID, user.Email, user.HashedPassword, cfg.jwtKeys, resetPasswordTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token")
+			return
+		}
+		cfg.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Chirpy password",
+			Body: fmt.Sprintf(
+				"Someone asked to reset the password for this account. If it was you, open the link below:\n\n%s/app/reset-password/?token=%s\n\nThe link expires in 1 hour. If it wasn't you, you can ignore this email.",
+				cfg.baseURL,
+				token,
+			),
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	token, err := auth.ParsePurposeJWT(params.Token, auth.PurposeResetPassword, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	// A token issued before the password last changed, whether by an
+	// earlier reset or from the account settings, is no longer valid
+	user, err := cfg.DB.GetUser(token.UserID)
+	if err != nil || user.Email != token.Email ||
+		subtle.ConstantTimeCompare([]byte(token.Resource), []byte(auth.PasswordFingerprint(user.HashedPassword))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	violations := cfg.passwordPolicy.Validate(params.Password, user.Email)
+	if violations != nil {
+		respondWithPasswordViolations(w, violations)
+		return
+	}
+
+	err = cfg.DB.ConsumeToken(token.TokenID, token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenUsed) {
+			respondWithError(w, http.StatusBadRequest, "Token has already been used")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't use token")
+		}
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password, cfg.argon2Params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	err = cfg.DB.UpdatePasswordHash(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password")
+		return
+	}
+
+	// Whoever had the old password is logged out, and the owner, who proved
+	// control of the mailbox, is no longer locked out
+	err = cfg.DB.RevokeUserSessions(user.ID, "")
+	if err == nil {
+		err = cfg.DB.InvalidateAccessTokens(user.ID)
+	}
+	if err == nil {
+		err = cfg.DB.ClearLoginAttempts(accountLoginKey(user.Email))
+	}
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
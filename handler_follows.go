@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+// handlerUsersFollow follows a user, or asks to if their account is
+// protected.
+func (cfg *apiConfig) handlerUsersFollow(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Status is "following", or "requested" while waiting for
+		// approval.
+		Status string `json:"status"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	followeeID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if followeeID == caller.User.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+
+	follow, err := cfg.DB.Follow(caller.User.ID, followeeID)
+	if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrBlocked) {
+		// Blocked users are told the account doesn't exist, as they are
+		// everywhere else
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user")
+		return
+	}
+
+	if follow.Pending {
+		respondWithJSON(w, http.StatusAccepted, response{Status: "requested"})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Status: "following"})
+}
+
+// handlerUsersUnfollow unfollows a user or withdraws a follow request.
+func (cfg *apiConfig) handlerUsersUnfollow(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	followeeID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.Unfollow(caller.User.ID, followeeID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "You don't follow this user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerFollowRequestsList lists the users waiting for the caller to
+// approve their follow.
+func (cfg *apiConfig) handlerFollowRequestsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	requests, err := cfg.DB.GetFollowRequests(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve follow requests")
+		return
+	}
+
+	resp := []relationResponse{}
+	for _, request := range requests {
+		user, err := cfg.DB.GetUser(request.FollowerID)
+		if err != nil {
+			continue
+		}
+		resp = append(resp, relationResponse{
+			UserID:    user.ID,
+			Handle:    user.Handle,
+			CreatedAt: request.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerFollowRequestsApprove(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	followerID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.ApproveFollowRequest(caller.User.ID, followerID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve follow request")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerFollowersRemove rejects a follow request or removes an existing
+// follower.
+func (cfg *apiConfig) handlerFollowersRemove(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	followerID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.Unfollow(followerID, caller.User.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User doesn't follow you")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove follower")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -14,11 +16,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -28,45 +25,131 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	accountKey := accountLoginKey(params.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+
+	// Unknown emails are checked against a dummy hash so they take as long
+	// as a wrong password and get the same response
 	user, err := cfg.DB.GetUserByEmail(params.Email)
-	if err != nil {
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
 		return
 	}
+	hashedPassword := user.HashedPassword
+	if err != nil {
+		hashedPassword = cfg.dummyPasswordHash
+	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	passwordErr := auth.CheckPasswordHash(params.Password, hashedPassword)
+	if err != nil || passwordErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid password")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-	)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+	if user.DeletedAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is pending deletion; restore it to log in")
+		return
+	}
+
+	// Upgrade hashes made with bcrypt or older argon2id parameters now that
+	// we have the plaintext password
+	if auth.PasswordNeedsRehash(user.HashedPassword, cfg.argon2Params) {
+		hashedPassword, err := auth.HashPassword(params.Password, cfg.argon2Params)
+		if err == nil {
+			err = cfg.DB.UpdatePasswordHash(user.ID, hashedPassword)
+		}
+		if err != nil {
+			log.Printf("Couldn't rehash password for user %d: %s", user.ID, err)
+		}
+	}
+
+	// With two-factor authentication the password only earns a challenge
+	// token to exchange at POST /api/login/mfa
+	if user.TOTPEnabled {
+		mfaToken, err := auth.MakePurposeJWT(user.ID, auth.PurposeMFAChallenge, "", cfg.jwtKeys, mfaChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	err = cfg.DB.ClearLoginAttempts(accountKey)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
 		return
 	}
 
+	cfg.respondWithNewSession(w, r, user)
+}
+
+// respondWithNewSession starts a session for user and responds with its
+// access and refresh tokens.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
 		return
 	}
 
-	err = cfg.DB.SaveRefreshToken(user.ID, refreshToken)
+	accessTokenID, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		return
+	}
+
+	sessionID, err := cfg.DB.SaveRefreshToken(user.ID, refreshToken, accessTokenID, deviceFromRequest(r))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token")
 		return
 	}
 
+	accessToken, err := auth.MakeJWT(
+		user.ID,
+		auth.TokenParams{
+			SessionID: sessionID,
+			TokenID:   accessTokenID,
+			Scopes:    scopesForRole(user.Role),
+		},
+		cfg.jwtKeys,
+		time.Hour,
+	)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
 		User: database.User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
+			ID:            user.ID,
+			Email:         user.Email,
+			Handle:        user.Handle,
+			DisplayName:   user.DisplayName,
+			Bio:           user.Bio,
+			Website:       user.Website,
+			Protected:     user.Protected,
+			IsChirpyRed:   user.IsChirpyRed,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			TOTPEnabled:   user.TOTPEnabled,
 		},
 		Token:        accessToken,
 		RefreshToken: refreshToken,
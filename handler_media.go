@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+	"github.com/TedMartell/ChirpyServerProject/internal/media"
+)
+
+const (
+	// defaultMediaDir is used unless MEDIA_DIR is set. Files are only
+	// served by handlerMediaServe, which checks who may see them; /app
+	// doesn't serve this directory.
+	defaultMediaDir      = "media"
+	defaultMediaMaxBytes = 5 << 20
+
+	// multipartOverhead allows for the multipart framing around the file
+	// when limiting the size of an upload request.
+	multipartOverhead = 64 << 10
+
+	maxImageSize        = 2048
+	thumbnailSize       = 320
+	maxAvatarSize       = 512
+	avatarThumbnailSize = 96
+	maxChirpAttachments = 4
+
+	// mediaUploadGrace is how long an upload is kept before being attached
+	// to anything.
+	mediaUploadGrace = 24 * time.Hour
+	// restrictedMediaTTL is how long signed URLs of images that aren't
+	// public stay valid.
+	restrictedMediaTTL = time.Hour
+)
+
+type mediaResponse struct {
+	ID           string `json:"id"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+func (cfg *apiConfig) mediaURL(name string) string {
+	return cfg.baseURL + "/media/" + name
+}
+
+// restrictedMediaURL returns a URL that lets viewerID see the file for
+// restrictedMediaTTL, as long as they still may.
+func (cfg *apiConfig) restrictedMediaURL(name string, viewerID int) string {
+	token, err := auth.MakeResourceJWT(viewerID, auth.PurposeViewMedia, name, cfg.jwtKeys, restrictedMediaTTL)
+	if err != nil {
+		log.Printf("Couldn't sign media URL: %s", err)
+		return cfg.mediaURL(name)
+	}
+	return cfg.mediaURL(name) + "?token=" + token
+}
+
+// mediaResponse describes an image that anyone may see, such as an avatar
+// or an attachment of a public chirp.
+func (cfg *apiConfig) mediaResponse(ref database.MediaRef) mediaResponse {
+	return mediaResponse{
+		ID:           ref.ID,
+		URL:          cfg.mediaURL(ref.ID),
+		ThumbnailURL: cfg.mediaURL(ref.ThumbnailID),
+		ContentType:  ref.ContentType,
+		Width:        ref.Width,
+		Height:       ref.Height,
+	}
+}
+
+// restrictedMediaResponse describes an image only some users may see, with
+// URLs signed for viewerID.
+func (cfg *apiConfig) restrictedMediaResponse(ref database.MediaRef, viewerID int) mediaResponse {
+	resp := cfg.mediaResponse(ref)
+	resp.URL = cfg.restrictedMediaURL(ref.ID, viewerID)
+	resp.ThumbnailURL = cfg.restrictedMediaURL(ref.ThumbnailID, viewerID)
+	return resp
+}
+
+// handlerMediaUpload stores an image to attach to chirps.
+func (cfg *apiConfig) handlerMediaUpload(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	ref, ok := cfg.processUpload(w, r, caller.User.ID, maxImageSize, thumbnailSize)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.restrictedMediaResponse(ref, caller.User.ID))
+}
+
+// handlerAvatarUpdate replaces the caller's avatar.
+func (cfg *apiConfig) handlerAvatarUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	ref, ok := cfg.processUpload(w, r, caller.User.ID, maxAvatarSize, avatarThumbnailSize)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DB.SetAvatar(caller.User.ID, &ref)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update avatar")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.mediaResponse(ref))
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	_, err := cfg.DB.SetAvatar(caller.User.ID, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove avatar")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// processUpload reads the image in the "file" field of a multipart upload,
+// sanitizes it and stores it with its thumbnail as uploaded by ownerID. On
+// failure it responds to the client and returns false.
+func (cfg *apiConfig) processUpload(w http.ResponseWriter, r *http.Request, ownerID, maxSize, thumbSize int) (database.MediaRef, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.mediaMaxBytes+multipartOverhead)
+	err := r.ParseMultipartForm(cfg.mediaMaxBytes)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large")
+		} else {
+			respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form")
+		}
+		return database.MediaRef{}, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing file")
+		return database.MediaRef{}, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, cfg.mediaMaxBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file")
+		return database.MediaRef{}, false
+	}
+	if int64(len(data)) > cfg.mediaMaxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large")
+		return database.MediaRef{}, false
+	}
+
+	processed, err := media.Process(data, maxSize, thumbSize)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+		return database.MediaRef{}, false
+	}
+	if errors.Is(err, media.ErrInvalidImage) || errors.Is(err, media.ErrTooManyPixels) || errors.Is(err, media.ErrTooManyFrames) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return database.MediaRef{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process image")
+		return database.MediaRef{}, false
+	}
+
+	ref := database.MediaRef{
+		ID:          processed.Original.Name,
+		ThumbnailID: processed.Thumbnail.Name,
+		ContentType: processed.Original.ContentType,
+		Width:       processed.Original.Width,
+		Height:      processed.Original.Height,
+	}
+
+	// Record the upload first so the janitor leaves the files alone
+	err = cfg.DB.SaveMedia(ref, ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload")
+		return database.MediaRef{}, false
+	}
+	for _, f := range []media.File{processed.Original, processed.Thumbnail} {
+		err = cfg.mediaStore.Save(f)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store image")
+			return database.MediaRef{}, false
+		}
+	}
+
+	return ref, true
+}
+
+// handlerMediaServe serves stored images. Names are content addresses, so
+// responses can be cached forever. Images that aren't public need a URL
+// signed for a user who may still see them, and are only cached privately.
+func (cfg *apiConfig) handlerMediaServe(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	path, ok := cfg.mediaStore.Path(name)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	cacheControl := "public, max-age=31536000, immutable"
+	visible, err := cfg.DB.MediaVisible(name, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access")
+		return
+	}
+	if !visible {
+		token, err := auth.ParsePurposeJWT(r.URL.Query().Get("token"), auth.PurposeViewMedia, cfg.jwtKeys)
+		if err == nil && token.Resource == name {
+			visible, err = cfg.DB.MediaVisible(name, token.UserID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check access")
+				return
+			}
+		}
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(restrictedMediaTTL.Seconds()))
+	}
+	if !visible {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read file")
+		return
+	}
+
+	w.Header().Set("Content-Type", media.ContentType(name))
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"`+name+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// purgeOrphanedMedia deletes images that nothing refers to anymore, such as
+// replaced avatars and the attachments of deleted chirps and drafts, once
+// their last upload is older than mediaUploadGrace.
+func (cfg *apiConfig) purgeOrphanedMedia(now time.Time) int {
+	purged, err := cfg.DB.PurgeOrphanedMedia(now.Add(-mediaUploadGrace), func(names []string) {
+		for _, name := range names {
+			path, ok := cfg.mediaStore.Path(name)
+			if !ok {
+				continue
+			}
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Janitor: couldn't remove image %s: %s", name, err)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Janitor: couldn't purge orphaned images: %s", err)
+		return 0
+	}
+	return purged
+}
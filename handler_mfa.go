@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+	if caller.User.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create TOTP secret")
+		return
+	}
+
+	err = cfg.DB.SetPendingTOTP(caller.User.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, caller.User.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+	if caller.User.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if caller.User.TOTPSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment first")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	accountKey := accountLoginKey(caller.User.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	step, ok := auth.ValidateTOTP(caller.User.TOTPSecret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	recoveryCodes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes")
+		return
+	}
+	hashes := make(map[string]string, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hash, err := auth.HashPassword(code, cfg.argon2Params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash recovery codes")
+			return
+		}
+		hashes[cfg.DB.RecoveryCodeKey(code)] = hash
+	}
+
+	err = cfg.DB.EnableTOTP(caller.User.ID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// handlerTOTPDisable turns off two-factor authentication. It takes the
+// password and a TOTP or recovery code, so that a stolen session or
+// password alone can't remove the second factor, and logs out every other
+// device.
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if !caller.User.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	accountKey := accountLoginKey(caller.User.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	err = auth.CheckPasswordHash(params.Password, caller.User.HashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Incorrect password")
+		return
+	}
+	err = cfg.verifySecondFactor(caller.User, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Invalid code")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	err = cfg.DB.DisableTOTP(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication")
+		return
+	}
+
+	// Sessions on other devices may be the attacker's, so they are logged
+	// out and every outstanding access token is invalidated
+	err = cfg.DB.RevokeUserSessions(caller.User.ID, caller.Claims.SessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+	err = cfg.DB.InvalidateAccessTokens(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginMFA exchanges an MFA challenge token and a TOTP or recovery
+// code for a session.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	challenge, err := auth.ParsePurposeJWT(params.MFAToken, auth.PurposeMFAChallenge, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(challenge.UserID)
+	if err != nil || !user.TOTPEnabled || user.DeletedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	accountKey := accountLoginKey(user.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+
+	err = cfg.verifySecondFactor(user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	err = cfg.DB.ClearLoginAttempts(accountKey)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, user)
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code, consuming it. A recovery code is only checked against the
+// hash stored under its key, so a guess costs a single hash at most; codes
+// issued before the keys were added are checked one by one. Callers must
+// reserve a login attempt first.
+func (cfg *apiConfig) verifySecondFactor(user database.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		key := cfg.DB.RecoveryCodeKey(recoveryCode)
+		if hash, ok := user.RecoveryCodeHashes[key]; ok {
+			if auth.CheckPasswordHash(recoveryCode, hash) == nil {
+				return cfg.DB.UseRecoveryCode(user.ID, key)
+			}
+			return auth.ErrPasswordMismatch
+		}
+		for _, hash := range user.RecoveryCodes {
+			if auth.CheckPasswordHash(recoveryCode, hash) == nil {
+				return cfg.DB.UseRecoveryCode(user.ID, hash)
+			}
+		}
+		return auth.ErrPasswordMismatch
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return auth.ErrPasswordMismatch
+	}
+	return cfg.DB.UseTOTPStep(user.ID, step)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 100
+)
+
+// notificationGroup collapses notifications of the same type about the same
+// chirp or conversation into one entry, e.g. "3 people followed you".
+type notificationGroup struct {
+	Type            string           `json:"type"`
+	Summary         string           `json:"summary"`
+	ChirpID         int              `json:"chirp_id,omitempty"`
+	ConversationID  int              `json:"conversation_id,omitempty"`
+	Actors          []*authorSummary `json:"actors"`
+	ActorCount      int              `json:"actor_count"`
+	NotificationIDs []int            `json:"notification_ids"`
+	Unread          bool             `json:"unread"`
+	LatestAt        time.Time        `json:"latest_at"`
+}
+
+// notificationVerbs describe each notification type for group summaries.
+var notificationVerbs = map[string]string{
+	database.NotificationFollow:        "followed you",
+	database.NotificationFollowRequest: "asked to follow you",
+	database.NotificationFollowAccept:  "accepted your follow request",
+	database.NotificationMention:       "mentioned you",
+	database.NotificationMessage:       "sent you a message",
+}
+
+// handlerNotificationsList pages through the caller's notifications, newest
+// first. ?unread=true leaves out read ones and ?group=false returns them
+// ungrouped. Pass the smallest notification ID seen as ?before= to get the
+// next page.
+func (cfg *apiConfig) handlerNotificationsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	query := r.URL.Query()
+
+	var err error
+	before := 0
+	if s := query.Get("before"); s != "" {
+		before, err = strconv.Atoi(s)
+		if err != nil || before < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
+	}
+	limit := defaultNotificationPageSize
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxNotificationPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxNotificationPageSize))
+			return
+		}
+	}
+	unreadOnly := query.Get("unread") == "true"
+
+	notifications, err := cfg.DB.GetNotifications(caller.User.ID, before, limit, unreadOnly)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	if query.Get("group") == "false" {
+		respondWithJSON(w, http.StatusOK, notifications)
+		return
+	}
+
+	actors, err := cfg.authorSummaries()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve actors")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, groupNotifications(notifications, actors))
+}
+
+// groupNotifications groups notifications, which must be sorted newest
+// first, keeping groups in the order of their newest notification.
+func groupNotifications(notifications []database.Notification, actors map[int]*authorSummary) []notificationGroup {
+	type groupKey struct {
+		notificationType string
+		chirpID          int
+		conversationID   int
+	}
+
+	groups := []notificationGroup{}
+	index := map[groupKey]int{}
+	for _, notification := range notifications {
+		key := groupKey{notification.Type, notification.ChirpID, notification.ConversationID}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, notificationGroup{
+				Type:           notification.Type,
+				ChirpID:        notification.ChirpID,
+				ConversationID: notification.ConversationID,
+				Actors:         []*authorSummary{},
+				LatestAt:       notification.CreatedAt,
+			})
+		}
+		group := &groups[i]
+		group.NotificationIDs = append(group.NotificationIDs, notification.ID)
+		group.Unread = group.Unread || notification.ReadAt == nil
+		actor, ok := actors[notification.ActorID]
+		if ok && !slices.Contains(group.Actors, actor) {
+			group.Actors = append(group.Actors, actor)
+		}
+	}
+
+	for i := range groups {
+		group := &groups[i]
+		group.ActorCount = len(group.Actors)
+		group.Summary = notificationSummary(group)
+	}
+	return groups
+}
+
+// notificationSummary describes a group, e.g. "@alice and 2 others
+// mentioned you".
+func notificationSummary(group *notificationGroup) string {
+	verb := notificationVerbs[group.Type]
+	switch group.ActorCount {
+	case 0:
+		return "Someone " + verb
+	case 1:
+		return "@" + group.Actors[0].Handle + " " + verb
+	case 2:
+		return fmt.Sprintf("@%s and @%s %s", group.Actors[0].Handle, group.Actors[1].Handle, verb)
+	default:
+		return fmt.Sprintf("@%s and %d others %s", group.Actors[0].Handle, group.ActorCount-1, verb)
+	}
+}
+
+// handlerNotificationsUnreadCount returns how many unread notifications the
+// caller has, for badges.
+func (cfg *apiConfig) handlerNotificationsUnreadCount(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		UnreadCount int `json:"unread_count"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	count, err := cfg.DB.CountUnreadNotifications(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count notifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{UnreadCount: count})
+}
+
+// handlerNotificationsRead marks the notifications in ids read, or all of
+// the caller's notifications if ids is left out.
+func (cfg *apiConfig) handlerNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IDs []int `json:"ids"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+			return
+		}
+	}
+
+	err := cfg.DB.MarkNotificationsRead(caller.User.ID, params.IDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notifications read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notificationPreferences reports whether each notification type is on.
+func notificationPreferences(user database.User) map[string]bool {
+	preferences := make(map[string]bool, len(database.NotificationTypes))
+	for _, notificationType := range database.NotificationTypes {
+		preferences[notificationType] = !user.DisabledNotifications[notificationType]
+	}
+	return preferences
+}
+
+func (cfg *apiConfig) handlerNotificationPreferencesGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	respondWithJSON(w, http.StatusOK, notificationPreferences(caller.User))
+}
+
+// handlerNotificationPreferencesUpdate turns notification types on or off.
+// Types left out of the request keep their setting.
+func (cfg *apiConfig) handlerNotificationPreferencesUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := map[string]bool{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	for notificationType := range params {
+		if !slices.Contains(database.NotificationTypes, notificationType) {
+			respondWithError(w, http.StatusBadRequest, "Unknown notification type "+notificationType)
+			return
+		}
+	}
+
+	user, err := cfg.DB.SetNotificationPreferences(caller.User.ID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences(user))
+}
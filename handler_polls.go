@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 25
+	minPollDuration     = 5 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+// pollInput is a poll as sent by the author of a chirp.
+type pollInput struct {
+	Options  []string   `json:"options"`
+	ClosesAt *time.Time `json:"closes_at,omitempty"`
+}
+
+// validate checks a poll on a chirp published at publishAt and returns it
+// ready to store.
+func (params pollInput) validate(publishAt time.Time) (*database.Poll, error) {
+	if len(params.Options) < minPollOptions || len(params.Options) > maxPollOptions {
+		return nil, fmt.Errorf("A poll must have %d to %d options", minPollOptions, maxPollOptions)
+	}
+	options := make([]string, 0, len(params.Options))
+	seen := map[string]bool{}
+	for _, option := range params.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return nil, errors.New("Poll options can't be empty")
+		}
+		if utf8.RuneCountInString(option) > maxPollOptionLength {
+			return nil, fmt.Errorf("Poll options can be at most %d characters", maxPollOptionLength)
+		}
+		if seen[strings.ToLower(option)] {
+			return nil, errors.New("Poll options must be different")
+		}
+		seen[strings.ToLower(option)] = true
+		options = append(options, option)
+	}
+
+	if params.ClosesAt == nil {
+		return nil, errors.New("A poll needs a closes_at")
+	}
+	closesAt := params.ClosesAt.UTC()
+	if closesAt.Before(publishAt.Add(minPollDuration)) || closesAt.After(publishAt.Add(maxPollDuration)) {
+		return nil, fmt.Errorf("A poll must close between %s and %s after the chirp is published", minPollDuration, maxPollDuration)
+	}
+
+	return &database.Poll{
+		Options:  options,
+		ClosesAt: closesAt,
+	}, nil
+}
+
+type pollOptionResponse struct {
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+// pollResponse is a poll as seen by one user. Tallies are left out until
+// the user has voted or the poll has closed.
+type pollResponse struct {
+	Options     []pollOptionResponse `json:"options"`
+	ClosesAt    time.Time            `json:"closes_at"`
+	Closed      bool                 `json:"closed"`
+	TotalVotes  *int                 `json:"total_votes,omitempty"`
+	VotedOption *int                 `json:"voted_option,omitempty"`
+}
+
+func newPollResponse(poll database.Poll, viewerID int) pollResponse {
+	resp := pollResponse{
+		Options:  make([]pollOptionResponse, 0, len(poll.Options)),
+		ClosesAt: poll.ClosesAt,
+		Closed:   poll.Closed(time.Now()),
+	}
+	if option, ok := poll.Votes[viewerID]; ok && viewerID != 0 {
+		resp.VotedOption = &option
+	}
+
+	showTally := resp.Closed || resp.VotedOption != nil
+	tally := poll.Tally()
+	for i, text := range poll.Options {
+		option := pollOptionResponse{Text: text}
+		if showTally {
+			option.Votes = &tally[i]
+		}
+		resp.Options = append(resp.Options, option)
+	}
+	if showTally {
+		total := len(poll.Votes)
+		resp.TotalVotes = &total
+	}
+	return resp
+}
+
+// handlerPollVotesCreate casts the caller's vote in a chirp's poll and
+// returns the poll with its tallies.
+func (cfg *apiConfig) handlerPollVotesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Option is the index of the chosen option.
+		Option *int `json:"option"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Option == nil {
+		respondWithError(w, http.StatusBadRequest, "Missing option")
+		return
+	}
+
+	chirp, err := cfg.DB.Vote(chirpID, caller.User.ID, *params.Option)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Poll not found")
+		return
+	}
+	if errors.Is(err, database.ErrInvalidOption) {
+		respondWithError(w, http.StatusBadRequest, "Invalid option")
+		return
+	}
+	if errors.Is(err, database.ErrPollClosed) {
+		respondWithError(w, http.StatusConflict, "Poll is closed")
+		return
+	}
+	if errors.Is(err, database.ErrAlreadyVoted) {
+		respondWithError(w, http.StatusConflict, "You have already voted")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record vote")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newPollResponse(*chirp.Poll, caller.User.ID))
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,7 +22,30 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.UserForRefreshToken(refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
+	}
+
+	accessTokenID, err := auth.MakeTokenID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		return
+	}
+
+	session, err := cfg.DB.RotateRefreshToken(refreshToken, newRefreshToken, accessTokenID, deviceFromRequest(r))
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		log.Printf("Security: reuse of rotated refresh token for user %d from %s, revoked session %s", session.UserID, r.RemoteAddr, session.FamilyID)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has already been used")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(session.UserID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token")
 		return
@@ -26,7 +53,12 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		auth.TokenParams{
+			SessionID: session.FamilyID,
+			TokenID:   accessTokenID,
+			Scopes:    scopesForRole(user.Role),
+		},
+		cfg.jwtKeys,
 		time.Hour,
 	)
 	if err != nil {
@@ -35,7 +67,8 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+// relationTarget reads the user to block or mute from the path. On failure
+// it responds to the client and returns false.
+func relationTarget(w http.ResponseWriter, r *http.Request) (int, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	if targetID == caller.User.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't block or mute yourself")
+		return 0, false
+	}
+	return targetID, true
+}
+
+func (cfg *apiConfig) handlerUsersBlock(w http.ResponseWriter, r *http.Request) {
+	cfg.changeRelation(w, r, cfg.DB.Block, "User not found", "Couldn't block user")
+}
+
+func (cfg *apiConfig) handlerUsersUnblock(w http.ResponseWriter, r *http.Request) {
+	cfg.changeRelation(w, r, cfg.DB.Unblock, "User is not blocked", "Couldn't unblock user")
+}
+
+func (cfg *apiConfig) handlerUsersMute(w http.ResponseWriter, r *http.Request) {
+	cfg.changeRelation(w, r, cfg.DB.Mute, "User not found", "Couldn't mute user")
+}
+
+func (cfg *apiConfig) handlerUsersUnmute(w http.ResponseWriter, r *http.Request) {
+	cfg.changeRelation(w, r, cfg.DB.Unmute, "User is not muted", "Couldn't unmute user")
+}
+
+func (cfg *apiConfig) changeRelation(w http.ResponseWriter, r *http.Request, change func(userID, targetID int) error, notFound, failure string) {
+	caller, _ := principalFromContext(r.Context())
+
+	targetID, ok := relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := change(caller.User.ID, targetID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, failure)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type relationResponse struct {
+	UserID    int       `json:"user_id"`
+	Handle    string    `json:"handle"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handlerUsersBlocksList(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelations(w, r, cfg.DB.GetBlocks)
+}
+
+func (cfg *apiConfig) handlerUsersMutesList(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelations(w, r, cfg.DB.GetMutes)
+}
+
+func (cfg *apiConfig) listRelations(w http.ResponseWriter, r *http.Request, get func(userID int) ([]database.Relation, error)) {
+	caller, _ := principalFromContext(r.Context())
+
+	relations, err := get(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users")
+		return
+	}
+
+	resp := []relationResponse{}
+	for _, relation := range relations {
+		user, err := cfg.DB.GetUser(relation.TargetID)
+		if err != nil {
+			continue
+		}
+		resp = append(resp, relationResponse{
+			UserID:    user.ID,
+			Handle:    user.Handle,
+			CreatedAt: relation.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		database.Session
+		Current bool `json:"current"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	dbSessions, err := cfg.DB.GetSessions(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions")
+		return
+	}
+
+	sessions := []session{}
+	for _, dbSession := range dbSessions {
+		sessions = append(sessions, session{
+			Session: dbSession,
+			Current: dbSession.ID == caller.Claims.SessionID,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("sessionID")
+
+	caller, _ := principalFromContext(r.Context())
+
+	err := cfg.DB.RevokeSession(caller.User.ID, sessionID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Session not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsDeleteAll logs the user out everywhere, including the
+// session the request was made from.
+func (cfg *apiConfig) handlerSessionsDeleteAll(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	err := cfg.DB.RevokeUserSessions(caller.User.ID, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+
+	err = cfg.DB.InvalidateAccessTokens(caller.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deviceFromRequest captures the client details recorded on a session.
+func deviceFromRequest(r *http.Request) database.Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return database.Device{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
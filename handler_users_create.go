@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/TedMartell/ChirpyServerProject/internal/auth"
 	"github.com/TedMartell/ChirpyServerProject/internal/database"
@@ -18,6 +21,9 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// Handle is optional; one is generated from the email if it's
+		// left out.
+		Handle string `json:"handle"`
 	}
 	type response struct {
 		database.User
@@ -31,23 +37,60 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	params.Email = strings.TrimSpace(params.Email)
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	handle := ""
+	if params.Handle != "" {
+		handle = database.NormalizeHandle(params.Handle)
+		err = database.ValidateHandle(handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	violations := cfg.passwordPolicy.Validate(params.Password, params.Email)
+	if violations != nil {
+		respondWithPasswordViolations(w, violations)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password, cfg.argon2Params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
 	}
 
-	user, err := cfg.DB.CreateUser(params.Email, hashedPassword)
+	user, err := cfg.DB.CreateUser(params.Email, hashedPassword, handle)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		respondWithError(w, http.StatusConflict, "Email is already in use")
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
 
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, response{
 		User: database.User{
 			ID:          user.ID,
 			Email:       user.Email,
+			Handle:      user.Handle,
 			IsChirpyRed: user.IsChirpyRed, // Include this field in the response
+			Role:        user.Role,
 		},
 	})
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+	"github.com/TedMartell/ChirpyServerProject/internal/mailer"
+)
+
+const defaultAccountDeletionGrace = 30 * 24 * time.Hour
+
+type accountDeletionResponse struct {
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// handlerUsersDelete deletes the caller's account after re-confirming their
+// password. With a grace period the account can be restored until it is
+// purged.
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	accountKey := accountLoginKey(caller.User.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+	err = auth.CheckPasswordHash(params.Password, caller.User.HashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Password is required to delete your account")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	cfg.deleteUser(w, caller.User, false)
+}
+
+// handlerAdminUsersDelete deletes any account. ?purge=true skips the grace
+// period.
+func (cfg *apiConfig) handlerAdminUsersDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	purge := false
+	if s := r.URL.Query().Get("purge"); s != "" {
+		purge, err = strconv.ParseBool(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "purge must be a boolean")
+			return
+		}
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+
+	cfg.deleteUser(w, user, purge)
+}
+
+// deleteUser schedules user for deletion, or purges them right away if
+// purge is set or there is no grace period.
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, user database.User, purge bool) {
+	if purge || cfg.accountDeletionGrace == 0 {
+		err := cfg.DB.PurgeUser(user.ID, cfg.deletedUserChirps)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete user")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err := cfg.DB.DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user")
+		return
+	}
+
+	purgeAt := time.Now().Add(cfg.accountDeletionGrace).UTC()
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf(
+			"Your Chirpy account is scheduled for deletion and will be removed for good on %s.\n\nTo keep it, restore it before then at %s/app/restore-account/.",
+			purgeAt.Format(time.RFC1123),
+			cfg.baseURL,
+		),
+	})
+
+	respondWithJSON(w, http.StatusAccepted, accountDeletionResponse{
+		PurgeAt: purgeAt,
+	})
+}
+
+// handlerUsersRestore cancels a pending deletion. It takes credentials
+// rather than a token because deleting the account ended every session.
+func (cfg *apiConfig) handlerUsersRestore(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	accountKey := accountLoginKey(params.Email)
+	ipKey := ipLoginKey(deviceFromRequest(r).IP)
+	if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	hashedPassword := user.HashedPassword
+	if err != nil {
+		hashedPassword = cfg.dummyPasswordHash
+	}
+
+	passwordErr := auth.CheckPasswordHash(params.Password, hashedPassword)
+	if err != nil || passwordErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+	err = cfg.releaseLoginAttempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+		return
+	}
+
+	if user.DeletedAt == nil {
+		respondWithError(w, http.StatusConflict, "Account is not pending deletion")
+		return
+	}
+	if time.Since(*user.DeletedAt) >= cfg.accountDeletionGrace {
+		respondWithError(w, http.StatusGone, "Account can no longer be restored")
+		return
+	}
+
+	err = cfg.DB.RestoreUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore account")
+		return
+	}
+	err = cfg.DB.ClearLoginAttempts(accountKey)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		log.Printf("Couldn't reset login attempts for user %d: %s", user.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const exportDownloadTTL = 15 * time.Minute
+
+type exportResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// DownloadURL is signed and only valid for a short time; fetch the
+	// export again for a fresh one.
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// handlerUsersExportCreate queues an export of the caller's data.
+func (cfg *apiConfig) handlerUsersExportCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	export, err := cfg.DB.CreateExport(caller.User.ID)
+	if errors.Is(err, database.ErrExportInProgress) {
+		respondWithError(w, http.StatusConflict, "An export is already in progress")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export")
+		return
+	}
+	cfg.queueExport()
+
+	w.Header().Set("Location", "/api/users/me/export/"+export.ID)
+	respondWithJSON(w, http.StatusAccepted, exportResponse{
+		ID:        export.ID,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+	})
+}
+
+// handlerUsersExportGet reports an export's status, with a download URL
+// once it is ready.
+func (cfg *apiConfig) handlerUsersExportGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	export, err := cfg.DB.GetExport(r.PathValue("exportID"), caller.User.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Export not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export")
+		return
+	}
+
+	resp := exportResponse{
+		ID:          export.ID,
+		Status:      export.Status,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+	if export.Status == database.ExportReady {
+		token, err := auth.MakeResourceJWT(caller.User.ID, auth.PurposeDownloadExport, export.ID, cfg.jwtKeys, exportDownloadTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign download URL")
+			return
+		}
+		resp.DownloadURL = fmt.Sprintf("%s/api/users/me/export/%s/download?token=%s", cfg.baseURL, export.ID, url.QueryEscape(token))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerUsersExportDownload serves an export's archive. The signed token
+// in the URL stands in for an access token so the link works in a browser.
+func (cfg *apiConfig) handlerUsersExportDownload(w http.ResponseWriter, r *http.Request) {
+	exportID := r.PathValue("exportID")
+
+	token, err := auth.ParsePurposeJWT(r.URL.Query().Get("token"), auth.PurposeDownloadExport, cfg.jwtKeys)
+	if err != nil || token.Resource != exportID {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired download link")
+		return
+	}
+
+	export, err := cfg.DB.GetExport(exportID, token.UserID)
+	if err != nil || export.Status != database.ExportReady || export.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusNotFound, "Export not found")
+		return
+	}
+
+	f, err := os.Open(filepath.Join(cfg.exportDir, export.File))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Export not found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, export.ID))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", *export.CompletedAt, f)
+}
@@ -2,73 +2,256 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/TedMartell/ChirpyServerProject/internal/auth"
 	"github.com/TedMartell/ChirpyServerProject/internal/database"
+	"github.com/TedMartell/ChirpyServerProject/internal/mailer"
 )
 
+const changeEmailTTL = 24 * time.Hour
+
+// handlerUsersUpdate applies a partial update: only fields present in the
+// request change. Changing the email or password requires the current
+// password, and a new email only takes effect once confirmed from that
+// address.
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password        *string `json:"password"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		profileParams
 	}
 	type response struct {
 		database.User
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	caller, _ := principalFromContext(r.Context())
+	existingUser := caller.User
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if params.Email != nil {
+		email := strings.TrimSpace(*params.Email)
+		params.Email = &email
+		if email == existingUser.Email {
+			params.Email = nil
+		} else if !validEmail(email) {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address")
+			return
+		}
+	}
+
+	profile, err := params.profileParams.validate()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	if params.Email != nil || params.Password != nil {
+		accountKey := accountLoginKey(existingUser.Email)
+		ipKey := ipLoginKey(deviceFromRequest(r).IP)
+		if !cfg.reserveLoginAttempt(w, accountKey, ipKey) {
+			return
+		}
+		err = auth.CheckPasswordHash(params.CurrentPassword, existingUser.HashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Current password is required to change email or password")
+			return
+		}
+		err = cfg.releaseLoginAttempt(accountKey, ipKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't reset login attempts")
+			return
+		}
+	}
+
+	if params.Password != nil {
+		violations := cfg.passwordPolicy.Validate(*params.Password, existingUser.Email)
+		if violations != nil {
+			respondWithPasswordViolations(w, violations)
+			return
+		}
+	}
+
+	if params.Email != nil {
+		_, err = cfg.DB.GetUserByEmail(*params.Email)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		if !errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check email")
+			return
+		}
+	}
+
+	// Profile changes go first since a taken handle is the only way they
+	// can fail now that everything else has been checked
+	if !params.profileParams.empty() {
+		_, err = cfg.DB.UpdateProfile(existingUser.ID, profile)
+		if errors.Is(err, database.ErrHandleTaken) {
+			respondWithError(w, http.StatusConflict, "Handle is already taken")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update profile")
+			return
+		}
+	}
+
+	if params.Password != nil {
+		hashedPassword, err := auth.HashPassword(*params.Password, cfg.argon2Params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+			return
+		}
+
+		err = cfg.DB.UpdatePasswordHash(existingUser.ID, hashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
+			return
+		}
+
+		// A new password logs out every other device and invalidates all
+		// outstanding access tokens, including the one used for this request
+		err = cfg.DB.RevokeUserSessions(existingUser.ID, caller.Claims.SessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+			return
+		}
+		err = cfg.DB.InvalidateAccessTokens(existingUser.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+			return
+		}
+	}
+
+	if params.Email != nil {
+		err = cfg.DB.SetPendingEmail(existingUser.ID, *params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
+			return
+		}
+
+		err = cfg.sendEmailChangeMails(existingUser, *params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send confirmation email")
+			return
+		}
+	}
+
+	user, err := cfg.DB.GetUser(existingUser.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user")
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	respondWithJSON(w, http.StatusOK, response{
+		User: database.User{
+			ID:            user.ID,
+			Email:         user.Email,
+			Handle:        user.Handle,
+			DisplayName:   user.DisplayName,
+			Bio:           user.Bio,
+			Website:       user.Website,
+			Protected:     user.Protected,
+			IsChirpyRed:   user.IsChirpyRed, // Ensure to include the ChirpyRed status
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			PendingEmail:  user.PendingEmail,
+			TOTPEnabled:   user.TOTPEnabled,
+		},
+	})
+}
+
+// sendEmailChangeMails asks newEmail to confirm the change and lets the
+// current address know one was requested.
+func (cfg *apiConfig) sendEmailChangeMails(user database.User, newEmail string) error {
+	token, err := auth.MakePurposeJWT(user.ID, auth.PurposeChangeEmail, newEmail, cfg.jwtKeys, changeEmailTTL)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return err
+	}
+
+	cfg.sendMail(mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Chirpy email address",
+		Body: fmt.Sprintf(
+			"Confirm that you want to use this address for your Chirpy account by opening the link below:\n\n%s/app/confirm-email/?token=%s\n\nThe link expires in 24 hours.",
+			cfg.baseURL,
+			token,
+		),
+	})
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy email address is being changed",
+		Body: fmt.Sprintf(
+			"Someone asked to change the email address of your Chirpy account to %s. It will change once the new address is confirmed.\n\nIf this wasn't you, reset your password right away.",
+			newEmail,
+		),
+	})
+	return nil
+}
+
+// handlerEmailChangeConfirm completes an email change with the token sent
+// to the new address.
+func (cfg *apiConfig) handlerEmailChangeConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(subject)
+	token, err := auth.ParsePurposeJWT(params.Token, auth.PurposeChangeEmail, cfg.jwtKeys)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
 		return
 	}
 
-	// Fetch the existing user to get their current IsChirpyRed status
-	existingUser, err := cfg.DB.GetUser(userIDInt)
+	err = cfg.DB.ConsumeToken(token.TokenID, token.ExpiresAt)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user")
+		if errors.Is(err, database.ErrTokenUsed) {
+			respondWithError(w, http.StatusBadRequest, "Token has already been used")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't use token")
+		}
 		return
 	}
 
-	// Update the user with the new email and password while keeping IsChirpyRed status
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword, existingUser.IsChirpyRed)
+	err = cfg.DB.ConfirmEmailChange(token.UserID, token.Email)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+		} else if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Email change is no longer pending")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't change email")
+		}
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
-		User: database.User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed, // Ensure to include the ChirpyRed status
-		},
-	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validEmail does a minimal sanity check; the confirmation mail is the
+// real test of an address.
+func validEmail(email string) bool {
+	local, domain, ok := strings.Cut(email, "@")
+	return ok && local != "" && domain != "" && !strings.ContainsAny(email, " \t\r\n")
 }
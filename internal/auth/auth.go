@@ -6,69 +6,143 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // ErrNoAuthHeaderIncluded -
 var ErrNoAuthHeaderIncluded = errors.New("not auth header included in request")
 
-// HashPassword -
-func HashPassword(password string) (string, error) {
-	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+// ErrTokenRevoked is returned for access tokens that are denylisted or were
+// issued before the user's tokens were invalidated.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// ErrRevocationCheck wraps errors from the RevocationChecker, which say
+// nothing about the token itself.
+var ErrRevocationCheck = errors.New("couldn't check token revocation")
+
+// RevocationChecker reports whether an otherwise valid access token has been
+// revoked.
+type RevocationChecker interface {
+	IsAccessTokenRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error)
+}
+
+// Scopes granted to access tokens.
+const (
+	ScopeChirpsWrite    = "chirps:write"
+	ScopeChirpsModerate = "chirps:moderate"
+	ScopeUsersWrite     = "users:write"
+	ScopeMessagesRead   = "messages:read"
+	ScopeMessagesWrite  = "messages:write"
+	ScopeAdminUsers     = "admin:users"
+	ScopeAdminAll       = "admin:*"
+)
+
+// Claims are the claims carried by access tokens. SessionID identifies the
+// refresh token family the access token was issued from; Scope is a space
+// separated list of granted scopes.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// Purpose marks tokens that are not access tokens, such as MFA
+	// challenges. ParseJWT rejects any token that has one.
+	Purpose string `json:"purpose,omitempty"`
+	Email   string `json:"email,omitempty"`
+	// Resource names the one thing a purpose token grants access to.
+	Resource string `json:"res,omitempty"`
+}
+
+// Purposes of tokens made by MakePurposeJWT.
+const (
+	// PurposeMFAChallenge tokens are returned by login for users with
+	// two-factor authentication enabled.
+	PurposeMFAChallenge  = "mfa_challenge"
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeChangeEmail   = "change_email"
+	// PurposeDownloadExport tokens sign data export download URLs.
+	PurposeDownloadExport = "download_export"
+	// PurposeViewMedia tokens sign URLs of images that aren't public.
+	PurposeViewMedia = "view_media"
+)
+
+// HasScope reports whether the claims grant scope. A granted scope ending in
+// ":*" grants every scope with the same prefix.
+func (c Claims) HasScope(scope string) bool {
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(granted, "*"); ok && strings.HasPrefix(scope, prefix) {
+			return true
+		}
 	}
-	return string(dat), nil
+	return false
 }
 
-// CheckPasswordHash -
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// TokenParams are the per-token values embedded in an access token.
+type TokenParams struct {
+	SessionID string
+	TokenID   string
+	Scopes    []string
 }
 
 // MakeJWT -
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   fmt.Sprintf("%d", userID),
+func MakeJWT(userID int, params TokenParams, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return keys.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        params.TokenID,
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", userID),
+		},
+		SessionID: params.SessionID,
+		Scope:     strings.Join(params.Scopes, " "),
 	})
-	return token.SignedString(signingKey)
 }
 
 // ValidateJWT -
-func ValidateJWT(tokenString, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+func ValidateJWT(tokenString string, keys *Keyring, revocations RevocationChecker) (string, error) {
+	claims, err := ParseJWT(tokenString, keys, revocations)
 	if err != nil {
 		return "", err
 	}
+	return claims.Subject, nil
+}
 
-	userIDString, err := token.Claims.GetSubject()
+// ParseJWT validates the access token, checks it has not been revoked and
+// returns its claims.
+func ParseJWT(tokenString string, keys *Keyring, revocations RevocationChecker) (Claims, error) {
+	claimsStruct, err := parseClaims(tokenString, keys)
 	if err != nil {
-		return "", err
+		return Claims{}, err
+	}
+	if claimsStruct.Purpose != "" {
+		return Claims{}, errors.New("not an access token")
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	userID, err := strconv.Atoi(claimsStruct.Subject)
 	if err != nil {
-		return "", err
+		return Claims{}, errors.New("invalid subject")
 	}
-	if issuer != string("chirpy") {
-		return "", errors.New("invalid issuer")
+	issuedAt := time.Time{}
+	if claimsStruct.IssuedAt != nil {
+		issuedAt = claimsStruct.IssuedAt.Time
+	}
+	revoked, err := revocations.IsAccessTokenRevoked(claimsStruct.ID, userID, issuedAt)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrRevocationCheck, err)
+	}
+	if revoked {
+		return Claims{}, ErrTokenRevoked
 	}
 
-	return userIDString, nil
+	return claimsStruct, nil
 }
 
 // GetBearerToken -
@@ -85,6 +159,115 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return splitAuth[1], nil
 }
 
+// PurposeToken is the content of a token made by MakePurposeJWT or
+// MakeResourceJWT. Email is optional and binds the token to an address, e.g.
+// for email verification.
+type PurposeToken struct {
+	UserID    int
+	TokenID   string
+	Email     string
+	Resource  string
+	ExpiresAt time.Time
+}
+
+// MakePurposeJWT issues a short-lived token for userID that is only
+// accepted by ParsePurposeJWT with the same purpose.
+func MakePurposeJWT(userID int, purpose, email string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makePurposeJWT(userID, Claims{Purpose: purpose, Email: email}, keys, expiresIn)
+}
+
+// MakePasswordResetJWT issues a PurposeResetPassword token for userID and
+// email that only works while the password hash is still passwordHash. The
+// token's Resource is the hash's PasswordFingerprint, so changing the
+// password invalidates every reset token issued before.
+func MakePasswordResetJWT(userID int, email, passwordHash string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makePurposeJWT(userID, Claims{
+		Purpose:  PurposeResetPassword,
+		Email:    email,
+		Resource: PasswordFingerprint(passwordHash),
+	}, keys, expiresIn)
+}
+
+// MakeResourceJWT is like MakePurposeJWT but binds the token to a single
+// resource, such as the file a download URL points at.
+func MakeResourceJWT(userID int, purpose, resource string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makePurposeJWT(userID, Claims{Purpose: purpose, Resource: resource}, keys, expiresIn)
+}
+
+func makePurposeJWT(userID int, claims Claims, keys *Keyring, expiresIn time.Duration) (string, error) {
+	tokenID, err := MakeTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		Issuer:    "chirpy",
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   fmt.Sprintf("%d", userID),
+	}
+	return keys.Sign(claims)
+}
+
+// ParsePurposeJWT validates a token made by MakePurposeJWT for purpose.
+func ParsePurposeJWT(tokenString, purpose string, keys *Keyring) (PurposeToken, error) {
+	claims, err := parseClaims(tokenString, keys)
+	if err != nil {
+		return PurposeToken{}, err
+	}
+	if claims.Purpose != purpose {
+		return PurposeToken{}, errors.New("token has the wrong purpose")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return PurposeToken{}, errors.New("invalid subject")
+	}
+	expiresAt := time.Time{}
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return PurposeToken{
+		UserID:    userID,
+		TokenID:   claims.ID,
+		Email:     claims.Email,
+		Resource:  claims.Resource,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func parseClaims(tokenString string, keys *Keyring) (Claims, error) {
+	claimsStruct := Claims{}
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		keys.verificationKey,
+		jwt.WithValidMethods([]string{AlgHS256, AlgEdDSA, AlgES256, AlgRS256}),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return Claims{}, err
+	}
+	if issuer != string("chirpy") {
+		return Claims{}, errors.New("invalid issuer")
+	}
+
+	return claimsStruct, nil
+}
+
+// MakeTokenID generates a random identifier for the jti claim.
+func MakeTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate random bytes: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // MakeRefreshToken generates a random 256-bit refresh token as a hex string.
 func MakeRefreshToken() (string, error) {
 	// Create a byte slice of length 32 (256 bits)
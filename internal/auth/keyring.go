@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+	AlgRS256 = "RS256"
+)
+
+// DefaultKeyID is the kid of the key built from JWT_SECRET. Tokens issued
+// before key IDs were introduced carry no kid and are verified with it.
+const DefaultKeyID = "default"
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("signing key is retired")
+)
+
+// Key is a single entry in a Keyring. Secret is set for HS256 keys and
+// Signer for the asymmetric algorithms.
+type Key struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+	Signer    crypto.Signer
+	Retired   bool
+}
+
+// Keyring holds the keys used to sign and verify access tokens. New tokens
+// are signed with the signing key; verification accepts any key that has not
+// been retired.
+//
+// To rotate keys without invalidating live tokens:
+//  1. Add the new key to the keyring file and deploy, so that verifiers and
+//     JWKS consumers learn about it.
+//  2. Point signing_kid at the new key and deploy.
+//  3. Once the access token lifetime has passed, mark the old key retired.
+//  4. Remove the retired key at leisure.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[string]Key
+	signingKID string
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: map[string]Key{},
+	}
+}
+
+// Add adds key to the keyring, replacing any key with the same ID.
+func (k *Keyring) Add(key Key) error {
+	if key.ID == "" {
+		return errors.New("key ID is required")
+	}
+	switch key.Algorithm {
+	case AlgHS256:
+		if len(key.Secret) == 0 {
+			return fmt.Errorf("key %s: HS256 requires a secret", key.ID)
+		}
+	case AlgEdDSA:
+		if _, ok := key.Signer.(ed25519.PrivateKey); !ok {
+			return fmt.Errorf("key %s: EdDSA requires an Ed25519 private key", key.ID)
+		}
+	case AlgES256:
+		ecKey, ok := key.Signer.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return fmt.Errorf("key %s: ES256 requires a P-256 private key", key.ID)
+		}
+	case AlgRS256:
+		if _, ok := key.Signer.(*rsa.PrivateKey); !ok {
+			return fmt.Errorf("key %s: RS256 requires an RSA private key", key.ID)
+		}
+	default:
+		return fmt.Errorf("key %s: unsupported algorithm %q", key.ID, key.Algorithm)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[key.ID] = key
+	return nil
+}
+
+// SetSigningKey selects the key used to sign new tokens.
+func (k *Keyring) SetSigningKey(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if key.Retired {
+		return ErrRetiredKey
+	}
+	k.signingKID = kid
+	return nil
+}
+
+// Sign signs claims with the current signing key.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key, ok := k.keys[k.signingKID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	if key.Algorithm == AlgHS256 {
+		return token.SignedString(key.Secret)
+	}
+	return token.SignedString(key.Signer)
+}
+
+// verificationKey is a jwt.Keyfunc resolving the token's kid.
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.Retired {
+		return nil, ErrRetiredKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %s does not sign with %s", kid, token.Method.Alg())
+	}
+
+	if key.Algorithm == AlgHS256 {
+		return key.Secret, nil
+	}
+	return key.Signer.Public(), nil
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	case AlgES256:
+		return jwt.SigningMethodES256
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of every asymmetric key that has not been
+// retired, the signing key first and the rest by kid. HS256 keys are never
+// published.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if key.Retired || key.Algorithm == AlgHS256 {
+			continue
+		}
+
+		jwk := JWK{
+			KeyID:     key.ID,
+			Algorithm: key.Algorithm,
+			Use:       "sig",
+		}
+		switch pub := key.Signer.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *ecdsa.PublicKey:
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		if (set.Keys[i].KeyID == k.signingKID) != (set.Keys[j].KeyID == k.signingKID) {
+			return set.Keys[i].KeyID == k.signingKID
+		}
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
+
+// LoadFile reads a keyring file of the form
+//
+//	{
+//	  "signing_kid": "2026-10",
+//	  "keys": [
+//	    {"kid": "2026-10", "alg": "EdDSA", "private_key_file": "keys/2026-10.pem"},
+//	    {"kid": "2026-07", "alg": "ES256", "private_key_file": "keys/2026-07.pem", "retired": true}
+//	  ]
+//	}
+//
+// Private keys are PKCS#8 PEM files; HS256 keys use "secret" instead.
+// The keys are added to k and the signing key is selected. Without
+// signing_kid, k keeps signing with the key it already has, such as the
+// default key, so new keys can be published before they are used; failing
+// that, the file's only key that isn't retired is used.
+func (k *Keyring) LoadFile(path string) error {
+	type keyFile struct {
+		KID            string `json:"kid"`
+		Alg            string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		Retired        bool   `json:"retired"`
+	}
+	type keyringFile struct {
+		SigningKID string    `json:"signing_kid"`
+		Keys       []keyFile `json:"keys"`
+	}
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file := keyringFile{}
+	err = json.Unmarshal(dat, &file)
+	if err != nil {
+		return fmt.Errorf("couldn't parse keyring: %w", err)
+	}
+
+	for _, kf := range file.Keys {
+		key := Key{
+			ID:        kf.KID,
+			Algorithm: kf.Alg,
+			Secret:    []byte(kf.Secret),
+			Retired:   kf.Retired,
+		}
+		if kf.PrivateKeyFile != "" {
+			key.Signer, err = readPrivateKey(kf.PrivateKeyFile)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", kf.KID, err)
+			}
+		}
+		err = k.Add(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if file.SigningKID != "" {
+		return k.SetSigningKey(file.SigningKID)
+	}
+
+	k.mu.RLock()
+	signingKID := k.signingKID
+	k.mu.RUnlock()
+	if signingKID != "" {
+		return nil
+	}
+	active := []string{}
+	for _, kf := range file.Keys {
+		if !kf.Retired {
+			active = append(active, kf.KID)
+		}
+	}
+	if len(active) != 1 {
+		return fmt.Errorf("keyring %s: signing_kid is required unless exactly one key is active", path)
+	}
+	return k.SetSigningKey(active[0])
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
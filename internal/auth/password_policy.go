@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Password policy rules reported in PolicyViolation.Rule.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleNotEmail  = "not_email"
+	RuleBreached  = "not_breached"
+)
+
+// PolicyViolation describes one password policy rule a password failed.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy is the set of rules new passwords must satisfy. Lengths
+// are counted in characters; a zero MaxLength disables the upper bound.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	DisallowEmail bool
+	Breached      *BreachedPasswords
+}
+
+// DefaultPasswordPolicy is used when no limits are configured.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     128,
+	DisallowEmail: true,
+}
+
+// Validate returns every rule password breaks, or nil if it is acceptable
+// for the account with the given email.
+func (p PasswordPolicy) Validate(password, email string) []PolicyViolation {
+	violations := []PolicyViolation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("Password must be at most %d characters", p.MaxLength),
+		})
+	}
+
+	if p.DisallowEmail && email != "" {
+		localPart, _, _ := strings.Cut(email, "@")
+		if strings.EqualFold(password, email) || strings.EqualFold(password, localPart) {
+			violations = append(violations, PolicyViolation{
+				Rule:    RuleNotEmail,
+				Message: "Password must not be your email address",
+			})
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleBreached,
+			Message: "Password has appeared in a data breach",
+		})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
+
+// BreachedPasswords looks passwords up in an offline list of breached
+// password SHA-1 hashes. Only an index of where each five hex character
+// prefix starts is kept in memory; like the k-anonymity range API of Have I
+// Been Pwned, a lookup reads the range of hashes sharing the password's
+// prefix, so the list can be as large as the full Pwned Passwords download.
+type BreachedPasswords struct {
+	file *os.File
+	// offsets[p] is where the first line with a prefix of at least p
+	// starts, so the range of prefix p ends at offsets[p+1].
+	offsets []int64
+}
+
+// breachedPrefixes is the number of five hex character prefixes.
+const breachedPrefixes = 1 << 20
+
+// LoadBreachedPasswords indexes a file of SHA-1 hashes sorted in ascending
+// order, one per line, optionally followed by ":<count>" as in the Pwned
+// Passwords downloads ordered by hash. Blank lines and lines starting with
+// '#' are ignored. Every other line is checked when the file is loaded. The
+// file is kept open for lookups.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := indexBreachedPasswords(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BreachedPasswords{
+		file:    f,
+		offsets: offsets,
+	}, nil
+}
+
+func indexBreachedPasswords(f *os.File, path string) ([]int64, error) {
+	offsets := make([]int64, breachedPrefixes+1)
+	nextPrefix := 0
+	previous := ""
+
+	r := bufio.NewReader(f)
+	var offset int64
+	lineNumber := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		lineStart := offset
+		offset += int64(len(line))
+		lineNumber++
+
+		hash, ok := parseBreachedLine(line)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, lineNumber)
+		}
+		if hash == "" {
+			continue
+		}
+		if hash < previous {
+			return nil, fmt.Errorf("%s:%d: hashes are not sorted", path, lineNumber)
+		}
+		previous = hash
+
+		prefix, _ := strconv.ParseUint(hash[:5], 16, 32)
+		for ; nextPrefix <= int(prefix); nextPrefix++ {
+			offsets[nextPrefix] = lineStart
+		}
+	}
+	for ; nextPrefix <= breachedPrefixes; nextPrefix++ {
+		offsets[nextPrefix] = offset
+	}
+	return offsets, nil
+}
+
+// parseBreachedLine returns the upper case hash on a line of a breached
+// password list, "" for lines without one, and false if the line is
+// malformed.
+func parseBreachedLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", true
+	}
+	hash, count, hasCount := strings.Cut(line, ":")
+	if len(hash) != 2*sha1.Size {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	if hasCount {
+		if _, err := strconv.ParseUint(count, 10, 64); err != nil {
+			return "", false
+		}
+	}
+	return strings.ToUpper(hash), true
+}
+
+// Contains reports whether password is in the breached set. Passwords are
+// reported as not breached if the list can't be read.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	prefix, _ := strconv.ParseUint(hash[:5], 16, 32)
+	start, end := b.offsets[prefix], b.offsets[prefix+1]
+	if start == end {
+		return false
+	}
+	buf := make([]byte, end-start)
+	_, err := b.file.ReadAt(buf, start)
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		candidate, ok := parseBreachedLine(line)
+		if ok && candidate == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeBreachedList(t *testing.T, lines []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "hunter2"}
+	hashes := []string{
+		// The lowest and highest prefixes, to cover the ends of the index
+		"0000000000000000000000000000000000000000",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+	}
+	for _, password := range breached {
+		hashes = append(hashes, sha1Hex(password))
+	}
+	sort.Strings(hashes)
+
+	lines := []string{"# Pwned Passwords", ""}
+	for i, hash := range hashes {
+		switch i % 3 {
+		case 0:
+			lines = append(lines, hash+":42")
+		case 1:
+			lines = append(lines, strings.ToLower(hash))
+		default:
+			lines = append(lines, hash+"\r")
+		}
+	}
+
+	b, err := LoadBreachedPasswords(writeBreachedList(t, lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.file.Close()
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"123456", true},
+		{"qwerty", true},
+		{"letmein", true},
+		{"hunter2", true},
+		{"Password", false},
+		{"correct horse battery staple", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := b.Contains(tt.password)
+			if got != tt.want {
+				t.Errorf("Contains(%q) = %t, want %t", tt.password, got, tt.want)
+			}
+		})
+	}
+
+	// Every prefix's range must hold exactly the hashes with that prefix
+	for _, hash := range hashes {
+		prefix := hash[:5]
+		var p int
+		for _, c := range prefix {
+			p = p<<4 | strings.IndexRune("0123456789ABCDEF", c)
+		}
+		buf := make([]byte, b.offsets[p+1]-b.offsets[p])
+		_, err := b.file.ReadAt(buf, b.offsets[p])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := parseBreachedLine(string(buf))
+		if got != hash {
+			t.Errorf("range of prefix %s = %q, want %s", prefix, buf, hash)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsErrors(t *testing.T) {
+	password := sha1Hex("password")
+	qwerty := sha1Hex("qwerty")
+
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{"unsorted", []string{qwerty, password}, "hashes are not sorted"},
+		{"short hash", []string{password[:39]}, "not a SHA-1 hash"},
+		{"not hex", []string{"Z" + password[1:]}, "not a SHA-1 hash"},
+		{"bad count", []string{password + ":many"}, "not a SHA-1 hash"},
+		{"line number", []string{"# comment", password, "nope"}, ":3: not a SHA-1 hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBreachedPasswords(writeBreachedList(t, tt.lines))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadBreachedPasswords error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
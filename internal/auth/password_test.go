@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeArgon2Hash(t *testing.T) {
+	// "salt0123salt0123" and "key01234key01234key01234key01234"
+	const salt = "c2FsdDAxMjNzYWx0MDEyMw"
+	const key = "a2V5MDEyMzRrZXkwMTIzNGtleTAxMjM0a2V5MDEyMzQ"
+
+	tests := []struct {
+		name    string
+		hash    string
+		want    Argon2Params
+		wantErr error
+	}{
+		{
+			name: "valid",
+			hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key,
+			want: Argon2Params{Memory: 19456, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		},
+		{
+			name: "other parameters",
+			hash: "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$" + key,
+			want: Argon2Params{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32},
+		},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", Argon2Params{}, ErrUnsupportedHash},
+		{"argon2i", "$argon2i$v=19$m=19456,t=2,p=1$" + salt + "$" + key, Argon2Params{}, ErrUnsupportedHash},
+		{"old version", "$argon2id$v=16$m=19456,t=2,p=1$" + salt + "$" + key, Argon2Params{}, ErrUnsupportedHash},
+		{"missing version", "$argon2id$m=19456,t=2,p=1$" + salt + "$" + key, Argon2Params{}, ErrUnsupportedHash},
+		{"bad parameters", "$argon2id$v=19$m=x,t=2,p=1$" + salt + "$" + key, Argon2Params{}, ErrUnsupportedHash},
+		{"padded salt", "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "==$" + key, Argon2Params{}, ErrUnsupportedHash},
+		{"bad key", "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$!", Argon2Params{}, ErrUnsupportedHash},
+		{"extra field", "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key + "$", Argon2Params{}, ErrUnsupportedHash},
+		{"empty", "", Argon2Params{}, ErrUnsupportedHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := decodeArgon2Hash(tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeArgon2Hash error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeArgon2Hash = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	params := Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hash, err := HashPassword("correct horse", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   string
+		params Argon2Params
+		want   bool
+	}{
+		{"same parameters", hash, params, false},
+		{"more memory", hash, Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more iterations", hash, Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more parallelism", hash, Argon2Params{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"longer salt", hash, Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32}, true},
+		{"longer key", hash, Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, true},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", params, true},
+		{"garbage", "not a hash", params, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PasswordNeedsRehash(tt.hash, tt.params)
+			if got != tt.want {
+				t.Errorf("PasswordNeedsRehash = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to the last six of its eight
+	// digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got := totpCode(key, tt.unix/int64(totpPeriod.Seconds()))
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / int64(totpPeriod.Seconds())
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, "050471", now, step, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", now, step, true},
+		{"spaces in code", rfc6238Secret, "050 471", now, step, true},
+		{"previous step", rfc6238Secret, "050471", now.Add(totpPeriod), step, true},
+		{"next step", rfc6238Secret, "050471", now.Add(-totpPeriod), step, true},
+		{"two steps late", rfc6238Secret, "050471", now.Add(2 * totpPeriod), 0, false},
+		{"wrong code", rfc6238Secret, "050472", now, 0, false},
+		{"eight digits", rfc6238Secret, "14050471", now, 0, false},
+		{"empty code", rfc6238Secret, "", now, 0, false},
+		{"invalid secret", "not base32!", "050471", now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := ValidateTOTP(tt.secret, tt.code, tt.now)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("ValidateTOTP = (%d, %t), want (%d, %t)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTokenUsed is returned by ConsumeToken for single-use tokens that have
+// already been used.
+var ErrTokenUsed = errors.New("token already used")
+
+// IsAccessTokenRevoked reports whether the access token with the given jti
+// has been denylisted, or was issued before the user's tokens were
+// invalidated. Tokens belonging to users that no longer exist are revoked.
+func (db *DB) IsAccessTokenRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := dbStructure.RevokedAccessTokens[tokenID]; ok && tokenID != "" {
+		return true, nil
+	}
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return true, nil
+	}
+
+	if user.TokensValidAfter == nil {
+		return false, nil
+	}
+	return issuedAt.Before(*user.TokensValidAfter), nil
+}
+
+// InvalidateAccessTokens rejects every access token issued to the user up to
+// now. The watermark is truncated to whole seconds to match the precision of
+// the iat claim; tokens issued within the same second as the call stay valid.
+func (db *DB) InvalidateAccessTokens(userID int) error {
+	return db.updateUser(userID, func(user *User) error {
+		watermark := time.Now().Truncate(time.Second)
+		user.TokensValidAfter = &watermark
+		return nil
+	})
+}
+
+// ConsumeToken marks the single-use token with the given jti as used until
+// it expires. It returns ErrTokenUsed if it was already consumed; the check
+// and the mark happen in one locked update, so a token can only be consumed
+// once however many requests race to use it.
+func (db *DB) ConsumeToken(tokenID string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.UsedTokens[tokenID]; ok {
+			return ErrTokenUsed
+		}
+		dbStructure.UsedTokens[tokenID] = expiresAt
+		return nil
+	})
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNotDeleted is returned by RestoreUser for users that are not pending
+// deletion.
+var ErrNotDeleted = errors.New("user is not pending deletion")
+
+// What happens to a deleted user's chirps.
+const (
+	ChirpsDelete    = "delete"
+	ChirpsAnonymize = "anonymize"
+)
+
+// DeleteUser schedules the user for deletion. Their sessions and access
+// tokens are revoked and their content hidden straight away, but nothing is
+// removed until PurgeDeletedUsers runs after the grace period, so the
+// deletion can be undone with RestoreUser until then.
+func (db *DB) DeleteUser(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now()
+		watermark := now.Truncate(time.Second)
+		user.DeletedAt = &now
+		user.TokensValidAfter = &watermark
+		dbStructure.Users[id] = user
+
+		for key, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == id {
+				revokeRefreshToken(*dbStructure, key)
+			}
+		}
+		return nil
+	})
+}
+
+// RestoreUser cancels a pending deletion.
+func (db *DB) RestoreUser(id int) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+		user.DeletedAt = nil
+		return nil
+	})
+}
+
+// PurgeDeletedUsers permanently removes users whose deletion was requested
+// before cutoff, along with everything they own. chirps is ChirpsDelete or
+// ChirpsAnonymize. All users are purged in a single write.
+func (db *DB) PurgeDeletedUsers(cutoff time.Time, chirps string) (int, error) {
+	purged := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			if user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+				continue
+			}
+			purgeUser(dbStructure, id, chirps)
+			purged++
+		}
+		if purged == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// PurgeUser permanently removes the user and everything they own right
+// away, skipping the grace period.
+func (db *DB) PurgeUser(id int, chirps string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[id]; !ok {
+			return ErrNotExist
+		}
+		purgeUser(dbStructure, id, chirps)
+		return nil
+	})
+}
+
+// purgeUser removes the user and every record that refers to them. Any new
+// collection keyed by user must be cleaned up here too. The ID is never
+// handed out again, so tokens issued to the user, which are only checked
+// against the user's ID, stop working with them rather than passing to a
+// new account.
+func purgeUser(dbStructure *DBStructure, id int, chirps string) {
+	dbStructure.NextUserID = max(dbStructure.NextUserID, id+1)
+	user := dbStructure.Users[id]
+
+	for key, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.UserID == id {
+			revokeRefreshToken(*dbStructure, key)
+		}
+	}
+
+	for chirpID, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID != id {
+			continue
+		}
+		// Scheduled chirps were never published, so there's nothing to keep
+		if chirps == ChirpsAnonymize && !chirp.Scheduled {
+			chirp.AuthorID = 0
+			dbStructure.Chirps[chirpID] = chirp
+		} else {
+			deleteChirp(*dbStructure, chirpID)
+		}
+	}
+
+	for key, relation := range dbStructure.Blocks {
+		if relation.UserID == id || relation.TargetID == id {
+			delete(dbStructure.Blocks, key)
+		}
+	}
+	for key, relation := range dbStructure.Mutes {
+		if relation.UserID == id || relation.TargetID == id {
+			delete(dbStructure.Mutes, key)
+		}
+	}
+
+	for key, follow := range dbStructure.Follows {
+		if follow.FollowerID == id || follow.FolloweeID == id {
+			delete(dbStructure.Follows, key)
+		}
+	}
+
+	removeFromConversations(*dbStructure, id)
+	removeVotes(*dbStructure, id)
+	removeNotifications(*dbStructure, func(n Notification) bool {
+		return n.UserID == id || n.ActorID == id
+	})
+	for draftID, draft := range dbStructure.Drafts {
+		if draft.AuthorID == id {
+			delete(dbStructure.Drafts, draftID)
+		}
+	}
+
+	// Shared uploads are kept for their other owners and for anonymized
+	// chirps that still show them
+	for mediaID, media := range dbStructure.Media {
+		if i := slices.Index(media.OwnerIDs, id); i >= 0 {
+			media.OwnerIDs = slices.Delete(media.OwnerIDs, i, i+1)
+			dbStructure.Media[mediaID] = media
+		}
+	}
+
+	// Expire the user's exports so the janitor deletes their archives too
+	for exportID, export := range dbStructure.Exports {
+		if export.UserID == id {
+			export.ExpiresAt = &time.Time{}
+			dbStructure.Exports[exportID] = export
+		}
+	}
+
+	delete(dbStructure.LoginAttempts, "email:"+strings.ToLower(user.Email))
+	delete(dbStructure.Users, id)
+}
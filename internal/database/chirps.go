@@ -1,31 +1,87 @@
 package database
 
+import "time"
+
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"` // Add the author_id field
+	ID          int        `json:"id"`
+	Body        string     `json:"body"`
+	AuthorID    int        `json:"author_id"` // Add the author_id field
+	Attachments []MediaRef `json:"attachments,omitempty"`
+	Visibility  string     `json:"visibility,omitempty"`
+	// MentionIDs are the users mentioned in the body.
+	MentionIDs []int `json:"mention_ids,omitempty"`
+	// PublishAt is when a scheduled chirp goes out. Until PublishScheduledChirps
+	// publishes it, a chirp is Scheduled and only its author can see it.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Scheduled bool       `json:"scheduled,omitempty"`
+	// ExpiresAt is when a self-expiring chirp disappears. It's hidden from
+	// then on and deleted by PurgeExpired.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Poll      *Poll      `json:"poll,omitempty"`
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+// ChirpParams are the optional parts of a new chirp.
+type ChirpParams struct {
+	Attachments []MediaRef
+	Visibility  string
+	MentionIDs  []int
+	// PublishAt schedules the chirp if it's in the future.
+	PublishAt *time.Time
+	ExpiresAt *time.Time
+	Poll      *Poll
+}
+
+func (db *DB) CreateChirp(body string, authorID int, params ChirpParams) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp = createChirp(*dbStructure, body, authorID, params)
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	id := len(dbStructure.Chirps) + 1
+	return chirp, nil
+}
+
+func createChirp(dbStructure DBStructure, body string, authorID int, params ChirpParams) Chirp {
+	id := 1
+	for existingID := range dbStructure.Chirps {
+		id = max(id, existingID+1)
+	}
 	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: authorID, // Store the author_id
+		ID:          id,
+		Body:        body,
+		AuthorID:    authorID, // Store the author_id
+		Attachments: params.Attachments,
+		Visibility:  params.Visibility,
+		MentionIDs:  params.MentionIDs,
+		PublishAt:   params.PublishAt,
+		Scheduled:   params.PublishAt != nil && params.PublishAt.After(time.Now()),
+		ExpiresAt:   params.ExpiresAt,
+		Poll:        params.Poll,
 	}
 	dbStructure.Chirps[id] = chirp
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return Chirp{}, err
+	if !chirp.Scheduled {
+		notifyMentions(dbStructure, chirp)
 	}
+	return chirp
+}
 
-	return chirp, nil
+// notifyMentions tells the users mentioned in a chirp who can see it that
+// they were mentioned.
+func notifyMentions(dbStructure DBStructure, chirp Chirp) {
+	for _, mentionID := range chirp.MentionIDs {
+		if chirpVisible(dbStructure, chirp, mentionID) {
+			notify(dbStructure, Notification{
+				UserID:  mentionID,
+				Type:    NotificationMention,
+				ActorID: chirp.AuthorID,
+				ChirpID: chirp.ID,
+			})
+		}
+	}
 }
 
 func (db *DB) GetChirps() ([]Chirp, error) {
@@ -34,8 +90,12 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 		return nil, err
 	}
 
+	now := time.Now()
 	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
 	for _, chirp := range dbStructure.Chirps {
+		if authorPendingDeletion(dbStructure, chirp) || chirp.expired(now) {
+			continue
+		}
 		chirps = append(chirps, chirp)
 	}
 
@@ -49,32 +109,45 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	}
 
 	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
+	if !ok || authorPendingDeletion(dbStructure, chirp) || chirp.expired(time.Now()) {
 		return Chirp{}, ErrNotExist
 	}
 
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	// Check if the chirp exists
-	if _, ok := dbStructure.Chirps[id]; !ok {
-		return ErrNotExist
-	}
+// authorPendingDeletion reports whether the chirp's author has asked for
+// their account to be deleted. Their chirps stay hidden until the account
+// is restored or purged.
+func authorPendingDeletion(dbStructure DBStructure, chirp Chirp) bool {
+	author, ok := dbStructure.Users[chirp.AuthorID]
+	return ok && author.DeletedAt != nil
+}
 
-	// Delete the chirp
-	delete(dbStructure.Chirps, id)
+// expired reports whether a self-expiring chirp's time is up. Expired
+// chirps are treated as deleted until PurgeExpired gets to them.
+func (chirp Chirp) expired(now time.Time) bool {
+	return chirp.ExpiresAt != nil && !chirp.ExpiresAt.After(now)
+}
 
-	// Write the updated structure back to the database
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
+func (db *DB) DeleteChirp(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		// Check if the chirp exists
+		if _, ok := dbStructure.Chirps[id]; !ok {
+			return ErrNotExist
+		}
+
+		// Delete the chirp
+		deleteChirp(*dbStructure, id)
+		return nil
+	})
+}
 
-	return nil
+// deleteChirp removes the chirp and everything that refers to it. Every
+// way of deleting a chirp goes through here.
+func deleteChirp(dbStructure DBStructure, id int) {
+	delete(dbStructure.Chirps, id)
+	removeNotifications(dbStructure, func(n Notification) bool {
+		return n.ChirpID == id
+	})
 }
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrNotParticipant is returned when a user acts on a conversation they
+// aren't part of.
+var ErrNotParticipant = errors.New("not a participant in the conversation")
+
+// Conversation is a private thread between two or more users. Messages are
+// kept apart from chirps and only ever shown to participants.
+type Conversation struct {
+	ID             int       `json:"id"`
+	ParticipantIDs []int     `json:"participant_ids"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastMessageAt  time.Time `json:"last_message_at"`
+	// Reads holds each participant's read receipt.
+	Reads map[int]ReadReceipt `json:"reads"`
+}
+
+// ReadReceipt records the newest message a participant has read.
+type ReadReceipt struct {
+	MessageID int       `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ConversationSummary is a conversation as listed for one participant.
+type ConversationSummary struct {
+	Conversation
+	LastMessage *Message
+	UnreadCount int
+}
+
+// CreateConversation starts a conversation between the users, or returns
+// the existing one with exactly the same participants. It returns
+// ErrNotExist if a user doesn't exist and ErrBlocked if any two of them
+// have blocked each other.
+func (db *DB) CreateConversation(participantIDs []int) (Conversation, bool, error) {
+	participants := slices.Clone(participantIDs)
+	slices.Sort(participants)
+	participants = slices.Compact(participants)
+
+	var conversation Conversation
+	created := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		for i, id := range participants {
+			user, ok := dbStructure.Users[id]
+			if !ok || user.DeletedAt != nil {
+				return ErrNotExist
+			}
+			for _, otherID := range participants[i+1:] {
+				if blockedEitherWay(*dbStructure, id, otherID) {
+					return ErrBlocked
+				}
+			}
+		}
+
+		for _, existing := range dbStructure.Conversations {
+			if slices.Equal(existing.ParticipantIDs, participants) {
+				conversation = existing
+				return errUnchanged
+			}
+		}
+
+		id := 1
+		for existingID := range dbStructure.Conversations {
+			id = max(id, existingID+1)
+		}
+		now := time.Now().UTC()
+		conversation = Conversation{
+			ID:             id,
+			ParticipantIDs: participants,
+			CreatedAt:      now,
+			LastMessageAt:  now,
+			Reads:          map[int]ReadReceipt{},
+		}
+		dbStructure.Conversations[id] = conversation
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	return conversation, created, nil
+}
+
+// GetConversation returns the conversation if userID takes part in it.
+func (db *DB) GetConversation(id, userID int) (Conversation, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation, ok := dbStructure.Conversations[id]
+	if !ok || !slices.Contains(conversation.ParticipantIDs, userID) {
+		return Conversation{}, ErrNotExist
+	}
+	return conversation, nil
+}
+
+// GetConversations returns userID's conversations, most recently active
+// first.
+func (db *DB) GetConversations(userID int) ([]ConversationSummary, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := map[int]*ConversationSummary{}
+	for id, conversation := range dbStructure.Conversations {
+		if slices.Contains(conversation.ParticipantIDs, userID) {
+			summaries[id] = &ConversationSummary{Conversation: conversation}
+		}
+	}
+	for _, message := range dbStructure.Messages {
+		summary, ok := summaries[message.ConversationID]
+		if !ok || !messageVisible(dbStructure, message, userID) {
+			continue
+		}
+		if summary.LastMessage == nil || message.ID > summary.LastMessage.ID {
+			summary.LastMessage = &message
+		}
+		if message.SenderID != userID && message.ID > summary.Reads[userID].MessageID {
+			summary.UnreadCount++
+		}
+	}
+
+	result := make([]ConversationSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[j].LastMessageAt.Before(result[i].LastMessageAt)
+	})
+	return result, nil
+}
+
+// CreateMessage adds a message from senderID to the conversation and marks
+// the conversation read up to it for the sender. It returns ErrBlocked if
+// the sender and another participant have blocked each other.
+func (db *DB) CreateMessage(conversationID, senderID int, body string) (Message, error) {
+	var message Message
+	err := db.update(func(dbStructure *DBStructure) error {
+		conversation, ok := dbStructure.Conversations[conversationID]
+		if !ok || !slices.Contains(conversation.ParticipantIDs, senderID) {
+			return ErrNotExist
+		}
+		for _, id := range conversation.ParticipantIDs {
+			if id != senderID && blockedEitherWay(*dbStructure, senderID, id) {
+				return ErrBlocked
+			}
+		}
+
+		id := 1
+		for existingID := range dbStructure.Messages {
+			id = max(id, existingID+1)
+		}
+		now := time.Now().UTC()
+		message = Message{
+			ID:             id,
+			ConversationID: conversationID,
+			SenderID:       senderID,
+			Body:           body,
+			CreatedAt:      now,
+		}
+		dbStructure.Messages[id] = message
+
+		conversation.LastMessageAt = now
+		conversation.Reads[senderID] = ReadReceipt{MessageID: id, ReadAt: now}
+		dbStructure.Conversations[conversationID] = conversation
+
+		for _, participantID := range conversation.ParticipantIDs {
+			notify(*dbStructure, Notification{
+				UserID:         participantID,
+				Type:           NotificationMessage,
+				ActorID:        senderID,
+				ConversationID: conversationID,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// GetMessages returns up to limit of the conversation's messages older than
+// the message with ID before, newest first. before 0 starts from the newest
+// message. Messages from users who have blocked userID, or whom userID has
+// blocked, are left out.
+func (db *DB) GetMessages(conversationID, userID, before, limit int) ([]Message, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, ok := dbStructure.Conversations[conversationID]
+	if !ok || !slices.Contains(conversation.ParticipantIDs, userID) {
+		return nil, ErrNotExist
+	}
+
+	messages := []Message{}
+	for _, message := range dbStructure.Messages {
+		if message.ConversationID != conversationID || (before != 0 && message.ID >= before) {
+			continue
+		}
+		if !messageVisible(dbStructure, message, userID) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[j].ID < messages[i].ID
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// MarkConversationRead moves userID's read receipt up to messageID, or to
+// the newest message if messageID is 0. Receipts never move backwards.
+func (db *DB) MarkConversationRead(conversationID, userID, messageID int) (ReadReceipt, error) {
+	var receipt ReadReceipt
+	err := db.update(func(dbStructure *DBStructure) error {
+		conversation, ok := dbStructure.Conversations[conversationID]
+		if !ok || !slices.Contains(conversation.ParticipantIDs, userID) {
+			return ErrNotExist
+		}
+
+		newest := 0
+		for _, message := range dbStructure.Messages {
+			if message.ConversationID == conversationID {
+				newest = max(newest, message.ID)
+			}
+		}
+		if messageID == 0 || messageID > newest {
+			messageID = newest
+		}
+
+		receipt = conversation.Reads[userID]
+		if messageID <= receipt.MessageID {
+			return errUnchanged
+		}
+		receipt = ReadReceipt{MessageID: messageID, ReadAt: time.Now().UTC()}
+		conversation.Reads[userID] = receipt
+		dbStructure.Conversations[conversationID] = conversation
+		return nil
+	})
+	if err != nil {
+		return ReadReceipt{}, err
+	}
+	return receipt, nil
+}
+
+func messageVisible(dbStructure DBStructure, message Message, userID int) bool {
+	return message.SenderID == userID || !blockedEitherWay(dbStructure, userID, message.SenderID)
+}
+
+// removeFromConversations deletes the user's messages and takes them out of
+// their conversations, deleting conversations nobody is left in.
+func removeFromConversations(dbStructure DBStructure, userID int) {
+	for id, message := range dbStructure.Messages {
+		if message.SenderID == userID {
+			delete(dbStructure.Messages, id)
+		}
+	}
+	for id, conversation := range dbStructure.Conversations {
+		i := slices.Index(conversation.ParticipantIDs, userID)
+		if i < 0 {
+			continue
+		}
+		conversation.ParticipantIDs = slices.Delete(conversation.ParticipantIDs, i, i+1)
+		delete(conversation.Reads, userID)
+		if len(conversation.ParticipantIDs) > 0 {
+			dbStructure.Conversations[id] = conversation
+			continue
+		}
+		delete(dbStructure.Conversations, id)
+		for messageID, message := range dbStructure.Messages {
+			if message.ConversationID == id {
+				delete(dbStructure.Messages, messageID)
+			}
+		}
+	}
+}
@@ -5,39 +5,88 @@ import (
 	"errors"
 	"os"
 	"sync"
+	"time"
 )
 
 var ErrNotExist = errors.New("resource does not exist")
 
 type DB struct {
-	path string
-	mu   *sync.RWMutex
+	path        string
+	mu          *sync.RWMutex
+	tokenPepper []byte
 }
 
 type DBStructure struct {
 	Chirps        map[int]Chirp           `json:"chirps"`
 	Users         map[int]User            `json:"users"`
 	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	// RevokedAccessTokens maps denylisted jtis to the time they expire.
+	RevokedAccessTokens map[string]time.Time    `json:"revoked_access_tokens"`
+	LoginAttempts       map[string]LoginAttempt `json:"login_attempts"`
+	// UsedTokens maps the jtis of consumed single-use tokens to the time
+	// they expire.
+	UsedTokens map[string]time.Time `json:"used_tokens"`
+	Exports    map[string]Export    `json:"exports"`
+	Media      map[string]Media     `json:"media"`
+	// Blocks and Mutes are keyed by "userID:targetID".
+	Blocks map[string]Relation `json:"blocks"`
+	Mutes  map[string]Relation `json:"mutes"`
+	// Follows are keyed by "followerID:followeeID".
+	Follows map[string]Follow `json:"follows"`
+	// Conversations and Messages hold direct messages, which never appear
+	// among chirps.
+	Conversations map[int]Conversation `json:"conversations"`
+	Messages      map[int]Message      `json:"messages"`
+	Notifications map[int]Notification `json:"notifications"`
+	Drafts        map[int]Draft        `json:"drafts"`
+	// NextUserID is the ID the next user gets. IDs are never reused, so
+	// nothing left over from a purged account can apply to a new one.
+	NextUserID int `json:"next_user_id"`
 }
 
-func NewDB(path string) (*DB, error) {
+// NewDB opens the database at path. tokenPepper is the server secret used to
+// hash refresh tokens before they are stored.
+func NewDB(path, tokenPepper string) (*DB, error) {
 	db := &DB{
-		path: path,
-		mu:   &sync.RWMutex{},
+		path:        path,
+		mu:          &sync.RWMutex{},
+		tokenPepper: []byte(tokenPepper),
 	}
 	err := db.ensureDB()
+	if err != nil {
+		return db, err
+	}
+	err = db.migrateRefreshTokens()
+	if err != nil {
+		return db, err
+	}
+	err = db.migrateHandles()
 	return db, err
 }
 
 func (db *DB) createDB() error {
 	dbStructure := DBStructure{
-		Chirps:        map[int]Chirp{},
-		Users:         map[int]User{},
-		RefreshTokens: map[string]RefreshToken{},
+		Chirps:              map[int]Chirp{},
+		Users:               map[int]User{},
+		RefreshTokens:       map[string]RefreshToken{},
+		RevokedAccessTokens: map[string]time.Time{},
+		LoginAttempts:       map[string]LoginAttempt{},
+		UsedTokens:          map[string]time.Time{},
+		Exports:             map[string]Export{},
+		Media:               map[string]Media{},
+		Blocks:              map[string]Relation{},
+		Mutes:               map[string]Relation{},
+		Follows:             map[string]Follow{},
+		Conversations:       map[int]Conversation{},
+		Messages:            map[int]Message{},
+		Notifications:       map[int]Notification{},
+		Drafts:              map[int]Draft{},
 	}
 	return db.writeDB(dbStructure)
 }
 
+// ensureDB creates the database if it doesn't exist. The caller must hold
+// db.mu or, as in NewDB, be the only user of db.
 func (db *DB) ensureDB() error {
 	_, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -47,6 +96,9 @@ func (db *DB) ensureDB() error {
 }
 
 func (db *DB) ResetDB() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	err := os.Remove(db.path)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil
@@ -54,10 +106,43 @@ func (db *DB) ResetDB() error {
 	return db.ensureDB()
 }
 
+// loadDB returns a snapshot of the database for reading. Changes must go
+// through update instead, since a snapshot may be stale by the time it
+// would be written back.
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.readDB()
+}
+
+// errUnchanged is returned by update callbacks that turned out to have
+// nothing to change, to skip the write.
+var errUnchanged = errors.New("unchanged")
+
+// update applies change to the database, holding the write lock from the
+// read until the result is written so that concurrent updates can't undo
+// each other. Nothing is written if change fails or returns errUnchanged,
+// which update reports as success.
+func (db *DB) update(change func(dbStructure *DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStructure, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	err = change(&dbStructure)
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.writeDB(dbStructure)
+}
 
+// readDB reads the database. The caller must hold db.mu.
+func (db *DB) readDB() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -68,21 +153,68 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return dbStructure, err
 	}
 
+	// Collections added after the database was created are missing from
+	// older files.
+	if dbStructure.RevokedAccessTokens == nil {
+		dbStructure.RevokedAccessTokens = map[string]time.Time{}
+	}
+	if dbStructure.LoginAttempts == nil {
+		dbStructure.LoginAttempts = map[string]LoginAttempt{}
+	}
+	if dbStructure.UsedTokens == nil {
+		dbStructure.UsedTokens = map[string]time.Time{}
+	}
+	if dbStructure.Exports == nil {
+		dbStructure.Exports = map[string]Export{}
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = map[string]Media{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[string]Relation{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[string]Relation{}
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = map[string]Follow{}
+	}
+	if dbStructure.Conversations == nil {
+		dbStructure.Conversations = map[int]Conversation{}
+	}
+	if dbStructure.Messages == nil {
+		dbStructure.Messages = map[int]Message{}
+	}
+	if dbStructure.Notifications == nil {
+		dbStructure.Notifications = map[int]Notification{}
+	}
+	if dbStructure.Drafts == nil {
+		dbStructure.Drafts = map[int]Draft{}
+	}
+	if dbStructure.NextUserID == 0 {
+		dbStructure.NextUserID = 1
+		for id := range dbStructure.Users {
+			dbStructure.NextUserID = max(dbStructure.NextUserID, id+1)
+		}
+	}
+
 	return dbStructure, nil
 }
 
+// writeDB replaces the database. The caller must hold db.mu for writing.
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, dat, 0600)
+	// Write to a temporary file and rename it over the database so that a
+	// write touching several collections is applied all at once or not at
+	// all
+	tmpPath := db.path + ".tmp"
+	err = os.WriteFile(tmpPath, dat, 0600)
 	if err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(tmpPath, db.path)
 }
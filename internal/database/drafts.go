@@ -0,0 +1,160 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrDraftChanged is returned by PublishDraft when the draft was edited
+// after its content was checked.
+var ErrDraftChanged = errors.New("draft has changed")
+
+// Draft is an unpublished chirp only its author can see. Its content is
+// checked again when it's published.
+type Draft struct {
+	ID          int        `json:"id"`
+	AuthorID    int        `json:"author_id"`
+	Body        string     `json:"body"`
+	Attachments []MediaRef `json:"attachments,omitempty"`
+	Visibility  string     `json:"visibility,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	TTL         string     `json:"ttl,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Poll        *Poll      `json:"poll,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// DraftParams are the editable parts of a draft.
+type DraftParams struct {
+	Body        string
+	Attachments []MediaRef
+	Visibility  string
+	PublishAt   *time.Time
+	TTL         string
+	ExpiresAt   *time.Time
+	Poll        *Poll
+}
+
+func (db *DB) CreateDraft(authorID int, params DraftParams) (Draft, error) {
+	var draft Draft
+	err := db.update(func(dbStructure *DBStructure) error {
+		id := 1
+		for existingID := range dbStructure.Drafts {
+			id = max(id, existingID+1)
+		}
+		now := time.Now().UTC()
+		draft = Draft{
+			ID:        id,
+			AuthorID:  authorID,
+			CreatedAt: now,
+		}
+		draft.apply(params, now)
+		dbStructure.Drafts[id] = draft
+		return nil
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns the author's drafts, most recently updated first.
+func (db *DB) GetDrafts(authorID int) ([]Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	drafts := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.AuthorID == authorID {
+			drafts = append(drafts, draft)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[j].UpdatedAt.Before(drafts[i].UpdatedAt)
+	})
+	return drafts, nil
+}
+
+// GetDraft returns the draft if authorID wrote it, and ErrNotExist
+// otherwise.
+func (db *DB) GetDraft(id, authorID int) (Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+
+	draft, ok := dbStructure.Drafts[id]
+	if !ok || draft.AuthorID != authorID {
+		return Draft{}, ErrNotExist
+	}
+	return draft, nil
+}
+
+// UpdateDraft replaces the content of the author's draft.
+func (db *DB) UpdateDraft(id, authorID int, params DraftParams) (Draft, error) {
+	var draft Draft
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		draft, ok = dbStructure.Drafts[id]
+		if !ok || draft.AuthorID != authorID {
+			return ErrNotExist
+		}
+		draft.apply(params, time.Now().UTC())
+		dbStructure.Drafts[id] = draft
+		return nil
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+func (db *DB) DeleteDraft(id, authorID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		draft, ok := dbStructure.Drafts[id]
+		if !ok || draft.AuthorID != authorID {
+			return ErrNotExist
+		}
+		delete(dbStructure.Drafts, id)
+		return nil
+	})
+}
+
+// PublishDraft turns the author's draft into a chirp with the given,
+// already validated, content and deletes the draft in the same write.
+// updatedAt is the UpdatedAt of the draft the content was taken from; if
+// the draft has been edited since, it returns ErrDraftChanged.
+func (db *DB) PublishDraft(id, authorID int, updatedAt time.Time, body string, params ChirpParams) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		draft, ok := dbStructure.Drafts[id]
+		if !ok || draft.AuthorID != authorID {
+			return ErrNotExist
+		}
+		if !draft.UpdatedAt.Equal(updatedAt) {
+			return ErrDraftChanged
+		}
+		delete(dbStructure.Drafts, id)
+		chirp = createChirp(*dbStructure, body, authorID, params)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (draft *Draft) apply(params DraftParams, now time.Time) {
+	draft.Body = params.Body
+	draft.Attachments = params.Attachments
+	draft.Visibility = params.Visibility
+	draft.PublishAt = params.PublishAt
+	draft.TTL = params.TTL
+	draft.ExpiresAt = params.ExpiresAt
+	draft.Poll = params.Poll
+	draft.UpdatedAt = now
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrExportInProgress is returned by CreateExport when the user already has
+// an export waiting to be built.
+var ErrExportInProgress = errors.New("an export is already in progress")
+
+// Export statuses.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Export is a request for a copy of a user's personal data. File is the
+// archive's name in the export directory once it is ready.
+type Export struct {
+	ID          string     `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	File        string     `json:"file,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// ExpiresAt is when the archive is deleted. It is unset while the
+	// export is pending.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateExport queues a new export for the user.
+func (db *DB) CreateExport(userID int) (Export, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return Export{}, err
+	}
+
+	export := Export{
+		ID:        id,
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	err = db.update(func(dbStructure *DBStructure) error {
+		for _, existing := range dbStructure.Exports {
+			if existing.UserID == userID && existing.Status == ExportPending {
+				return ErrExportInProgress
+			}
+		}
+		dbStructure.Exports[id] = export
+		return nil
+	})
+	if err != nil {
+		return Export{}, err
+	}
+	return export, nil
+}
+
+// GetExport returns the export, or ErrNotExist if it does not belong to the
+// user.
+func (db *DB) GetExport(id string, userID int) (Export, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Export{}, err
+	}
+
+	export, ok := dbStructure.Exports[id]
+	if !ok || export.UserID != userID {
+		return Export{}, ErrNotExist
+	}
+	return export, nil
+}
+
+// GetPendingExports returns the exports still waiting to be built, oldest
+// first.
+func (db *DB) GetPendingExports() ([]Export, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := []Export{}
+	for _, export := range dbStructure.Exports {
+		if export.Status == ExportPending {
+			exports = append(exports, export)
+		}
+	}
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].CreatedAt.Before(exports[j].CreatedAt)
+	})
+	return exports, nil
+}
+
+// CompleteExport records the outcome of building an export. A ready export
+// is kept until expiresAt; failed exports are kept for the same time so the
+// user can see what happened.
+func (db *DB) CompleteExport(id, file string, failed bool, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		export, ok := dbStructure.Exports[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		export.Status = ExportReady
+		if failed {
+			export.Status = ExportFailed
+		}
+		export.File = file
+		export.CompletedAt = &now
+		export.ExpiresAt = &expiresAt
+		dbStructure.Exports[id] = export
+		return nil
+	})
+}
+
+// PurgeExpiredExports deletes exports that expired before now and returns
+// them so their archives can be removed.
+func (db *DB) PurgeExpiredExports(now time.Time) ([]Export, error) {
+	purged := []Export{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, export := range dbStructure.Exports {
+			if export.ExpiresAt != nil && export.ExpiresAt.Before(now) {
+				delete(dbStructure.Exports, id)
+				purged = append(purged, export)
+			}
+		}
+		if len(purged) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrBlocked is returned when one of the users has blocked the other.
+var ErrBlocked = errors.New("user is blocked")
+
+// Follow is one user following another. Follows of protected accounts are
+// Pending until the followed user approves them.
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	Pending    bool      `json:"pending,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Follow makes followerID follow followeeID, or requests to if followeeID
+// is protected. Following again returns the existing follow.
+func (db *DB) Follow(followerID, followeeID int) (Follow, error) {
+	var follow Follow
+	err := db.update(func(dbStructure *DBStructure) error {
+		followee, ok := dbStructure.Users[followeeID]
+		if !ok || followee.DeletedAt != nil {
+			return ErrNotExist
+		}
+		if blockedEitherWay(*dbStructure, followerID, followeeID) {
+			return ErrBlocked
+		}
+
+		key := relationKey(followerID, followeeID)
+		if existing, ok := dbStructure.Follows[key]; ok {
+			follow = existing
+			return errUnchanged
+		}
+		follow = Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			Pending:    followee.Protected,
+			CreatedAt:  time.Now().UTC(),
+		}
+		dbStructure.Follows[key] = follow
+
+		notificationType := NotificationFollow
+		if follow.Pending {
+			notificationType = NotificationFollowRequest
+		}
+		notify(*dbStructure, Notification{
+			UserID:  followeeID,
+			Type:    notificationType,
+			ActorID: followerID,
+		})
+		return nil
+	})
+	if err != nil {
+		return Follow{}, err
+	}
+	return follow, nil
+}
+
+// Unfollow ends a follow or withdraws a follow request. It returns
+// ErrNotExist if there was neither.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := relationKey(followerID, followeeID)
+		follow, ok := dbStructure.Follows[key]
+		if !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.Follows, key)
+
+		// A withdrawn or rejected request no longer needs answering
+		if follow.Pending {
+			removeNotifications(*dbStructure, func(n Notification) bool {
+				return n.Type == NotificationFollowRequest && n.UserID == followeeID && n.ActorID == followerID
+			})
+		}
+		return nil
+	})
+}
+
+// ApproveFollowRequest accepts followerID's pending request to follow
+// userID.
+func (db *DB) ApproveFollowRequest(userID, followerID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := relationKey(followerID, userID)
+		follow, ok := dbStructure.Follows[key]
+		if !ok || !follow.Pending {
+			return ErrNotExist
+		}
+		follow.Pending = false
+		dbStructure.Follows[key] = follow
+
+		// The request is dealt with, and the follower hears it was accepted
+		removeNotifications(*dbStructure, func(n Notification) bool {
+			return n.Type == NotificationFollowRequest && n.UserID == userID && n.ActorID == followerID
+		})
+		notify(*dbStructure, Notification{
+			UserID:  followerID,
+			Type:    NotificationFollowAccept,
+			ActorID: userID,
+		})
+		return nil
+	})
+}
+
+// GetFollowRequests returns the pending requests to follow userID, oldest
+// first.
+func (db *DB) GetFollowRequests(userID int) ([]Follow, error) {
+	return db.getFollows(func(follow Follow) bool {
+		return follow.FolloweeID == userID && follow.Pending
+	})
+}
+
+// GetFollowers returns the users following userID.
+func (db *DB) GetFollowers(userID int) ([]Follow, error) {
+	return db.getFollows(func(follow Follow) bool {
+		return follow.FolloweeID == userID && !follow.Pending
+	})
+}
+
+// GetFollowing returns the follows and follow requests made by userID.
+func (db *DB) GetFollowing(userID int) ([]Follow, error) {
+	return db.getFollows(func(follow Follow) bool {
+		return follow.FollowerID == userID
+	})
+}
+
+func (db *DB) getFollows(match func(Follow) bool) ([]Follow, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	follows := []Follow{}
+	for _, follow := range dbStructure.Follows {
+		if match(follow) {
+			follows = append(follows, follow)
+		}
+	}
+	sort.Slice(follows, func(i, j int) bool {
+		return follows[i].CreatedAt.Before(follows[j].CreatedAt)
+	})
+	return follows, nil
+}
+
+// isFollower reports whether followerID follows followeeID with an
+// approved follow.
+func isFollower(dbStructure DBStructure, followerID, followeeID int) bool {
+	follow, ok := dbStructure.Follows[relationKey(followerID, followeeID)]
+	return ok && !follow.Pending
+}
+
+// removeFollows deletes follows and requests between the two users in
+// either direction.
+func removeFollows(dbStructure DBStructure, userID, otherID int) {
+	delete(dbStructure.Follows, relationKey(userID, otherID))
+	delete(dbStructure.Follows, relationKey(otherID, userID))
+}
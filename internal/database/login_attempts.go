@@ -0,0 +1,120 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrLoginLocked is returned when a key is locked out after too many
+// failed logins.
+var ErrLoginLocked = errors.New("login locked")
+
+// loginAttemptTTL is how long failures are remembered after the last one,
+// or after a lockout ends.
+const loginAttemptTTL = 24 * time.Hour
+
+// LoginAttempt tracks consecutive failed logins for a key such as an email
+// address or client IP.
+type LoginAttempt struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// GetLoginAttempts returns every key with recorded failures, most recent
+// failure first.
+func (db *DB) GetLoginAttempts() ([]LoginAttempt, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	attempts := []LoginAttempt{}
+	for _, attempt := range dbStructure.LoginAttempts {
+		if attempt.ExpiresAt.Before(now) {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[j].LastFailure.Before(attempts[i].LastFailure)
+	})
+
+	return attempts, nil
+}
+
+// ReserveLoginAttempt admits a login attempt for key and counts it as a
+// failure until ReleaseLoginAttempt is called, all in one locked update, so
+// parallel guesses can't get past the lockout. lockFor is given the new
+// failure count and returns how long further attempts should be refused.
+// A key that is still locked out returns ErrLoginLocked along with its
+// attempts.
+func (db *DB) ReserveLoginAttempt(key string, lockFor func(failures int) time.Duration) (LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now()
+		var ok bool
+		attempt, ok = dbStructure.LoginAttempts[key]
+		if !ok || attempt.ExpiresAt.Before(now) {
+			attempt = LoginAttempt{Key: key}
+		}
+		if attempt.LockedUntil.After(now) {
+			return ErrLoginLocked
+		}
+
+		attempt.Failures++
+		attempt.LastFailure = now
+		attempt.LockedUntil = now.Add(lockFor(attempt.Failures))
+		attempt.ExpiresAt = attempt.LockedUntil.Add(loginAttemptTTL)
+		dbStructure.LoginAttempts[key] = attempt
+		return nil
+	})
+	if errors.Is(err, ErrLoginLocked) {
+		return attempt, err
+	}
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// ReleaseLoginAttempt takes back a failure counted by ReserveLoginAttempt
+// once the attempt succeeded, shortening the lockout to what the remaining
+// failures call for.
+func (db *DB) ReleaseLoginAttempt(key string, lockFor func(failures int) time.Duration) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		attempt, ok := dbStructure.LoginAttempts[key]
+		if !ok {
+			return errUnchanged
+		}
+
+		attempt.Failures--
+		if attempt.Failures <= 0 {
+			delete(dbStructure.LoginAttempts, key)
+			return nil
+		}
+		lockedUntil := attempt.LastFailure.Add(lockFor(attempt.Failures))
+		if lockedUntil.Before(attempt.LockedUntil) {
+			attempt.LockedUntil = lockedUntil
+		}
+		dbStructure.LoginAttempts[key] = attempt
+		return nil
+	})
+}
+
+// ClearLoginAttempts forgets the failures recorded for key. It returns
+// ErrNotExist if there were none.
+func (db *DB) ClearLoginAttempts(key string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.LoginAttempts[key]; !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.LoginAttempts, key)
+		return nil
+	})
+}
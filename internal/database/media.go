@@ -0,0 +1,175 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// MediaRef describes a stored image. IDs are content addresses in the media
+// store, so a reference stays valid for as long as the file is kept.
+type MediaRef struct {
+	ID          string `json:"id"`
+	ThumbnailID string `json:"thumbnail_id"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// Media records who uploaded an image. Identical uploads share a record.
+// UploadedAt is refreshed by every upload of the image, so a file being
+// uploaded again isn't purged before it can be attached.
+type Media struct {
+	MediaRef
+	OwnerIDs   []int     `json:"owner_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// SaveMedia records that the user uploaded the image. It must be called
+// before the files are stored, so that PurgeOrphanedMedia can't remove them
+// in between.
+func (db *DB) SaveMedia(ref MediaRef, ownerID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		media, ok := dbStructure.Media[ref.ID]
+		if !ok {
+			media = Media{
+				MediaRef:  ref,
+				CreatedAt: now,
+			}
+		}
+		media.UploadedAt = now
+		if !slices.Contains(media.OwnerIDs, ownerID) {
+			media.OwnerIDs = append(media.OwnerIDs, ownerID)
+		}
+		dbStructure.Media[ref.ID] = media
+		return nil
+	})
+}
+
+// GetOwnedMedia returns the images with the given IDs, in order. It returns
+// ErrNotExist unless the user uploaded every one of them.
+func (db *DB) GetOwnedMedia(ids []string, ownerID int) ([]MediaRef, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]MediaRef, 0, len(ids))
+	for _, id := range ids {
+		media, ok := dbStructure.Media[id]
+		if !ok || !slices.Contains(media.OwnerIDs, ownerID) {
+			return nil, ErrNotExist
+		}
+		refs = append(refs, media.MediaRef)
+	}
+	return refs, nil
+}
+
+// MediaVisible reports whether viewerID may see the stored file with the
+// given name, an image or its thumbnail. Avatars are public; attachments
+// can be seen by whoever can see a chirp they're attached to, and by the
+// users who uploaded them.
+func (db *DB) MediaVisible(name string, viewerID int) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	for _, user := range dbStructure.Users {
+		if user.Avatar != nil && user.Avatar.hasFile(name) {
+			return true, nil
+		}
+	}
+	for _, chirp := range dbStructure.Chirps {
+		for _, attachment := range chirp.Attachments {
+			if attachment.hasFile(name) && chirpVisible(dbStructure, chirp, viewerID) {
+				return true, nil
+			}
+		}
+	}
+	if viewerID == 0 {
+		return false, nil
+	}
+	for _, media := range dbStructure.Media {
+		if media.hasFile(name) && slices.Contains(media.OwnerIDs, viewerID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PurgeOrphanedMedia deletes the records of images that no avatar, chirp or
+// draft refers to and that weren't uploaded since uploadedBefore. remove is
+// called with the names of their files while the database is locked, so an
+// upload of the same image can't record it again until they are gone.
+func (db *DB) PurgeOrphanedMedia(uploadedBefore time.Time, remove func(names []string)) (int, error) {
+	purged := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		// Different images can share a thumbnail, so files are kept as long
+		// as anything still refers to them
+		refs := []MediaRef{}
+		for _, user := range dbStructure.Users {
+			if user.Avatar != nil {
+				refs = append(refs, *user.Avatar)
+			}
+		}
+		for _, chirp := range dbStructure.Chirps {
+			refs = append(refs, chirp.Attachments...)
+		}
+		for _, draft := range dbStructure.Drafts {
+			refs = append(refs, draft.Attachments...)
+		}
+		referenced := map[string]bool{}
+		for _, ref := range refs {
+			referenced[ref.ID] = true
+			referenced[ref.ThumbnailID] = true
+		}
+
+		orphaned := []MediaRef{}
+		for id, media := range dbStructure.Media {
+			if referenced[id] || !media.UploadedAt.Before(uploadedBefore) {
+				referenced[media.ID] = true
+				referenced[media.ThumbnailID] = true
+				continue
+			}
+			orphaned = append(orphaned, media.MediaRef)
+		}
+		if len(orphaned) == 0 {
+			return errUnchanged
+		}
+
+		names := []string{}
+		for _, ref := range orphaned {
+			delete(dbStructure.Media, ref.ID)
+			for _, name := range []string{ref.ID, ref.ThumbnailID} {
+				if !referenced[name] {
+					names = append(names, name)
+					referenced[name] = true
+				}
+			}
+		}
+		remove(names)
+		purged = len(orphaned)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (ref MediaRef) hasFile(name string) bool {
+	return ref.ID == name || ref.ThumbnailID == name
+}
+
+// SetAvatar sets or, with a nil ref, removes the user's avatar.
+func (db *DB) SetAvatar(userID int, ref *MediaRef) (User, error) {
+	var updated User
+	err := db.updateUser(userID, func(user *User) error {
+		user.Avatar = ref
+		updated = *user
+		return nil
+	})
+	return updated, err
+}
@@ -0,0 +1,88 @@
+package database
+
+import "errors"
+
+// ErrTOTPReplay is returned when a TOTP code from an already used time step
+// is presented again.
+var ErrTOTPReplay = errors.New("totp code already used")
+
+// SetPendingTOTP stores a TOTP secret that becomes active once EnableTOTP
+// confirms the user can generate codes for it.
+func (db *DB) SetPendingTOTP(id int, secret string) error {
+	return db.updateUser(id, func(user *User) error {
+		user.TOTPSecret = secret
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		user.RecoveryCodeHashes = nil
+		user.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret.
+// step is the time step of the confirmation code and recoveryCodeHashes
+// maps the RecoveryCodeKey of each recovery code to its hash.
+func (db *DB) EnableTOTP(id int, step int64, recoveryCodeHashes map[string]string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.TOTPSecret == "" {
+			return ErrNotExist
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodeHashes = recoveryCodeHashes
+		user.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// DisableTOTP turns off two-factor authentication and forgets the secret
+// and recovery codes.
+func (db *DB) DisableTOTP(id int) error {
+	return db.updateUser(id, func(user *User) error {
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		user.RecoveryCodeHashes = nil
+		user.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns
+// ErrTOTPReplay if step is not newer than the last accepted one.
+func (db *DB) UseTOTPStep(id int, step int64) error {
+	return db.updateUser(id, func(user *User) error {
+		if step <= user.TOTPLastStep {
+			return ErrTOTPReplay
+		}
+		user.TOTPLastStep = step
+		return nil
+	})
+}
+
+// RecoveryCodeKey returns the key a recovery code's hash is stored under in
+// User.RecoveryCodeHashes. It is keyed with the token pepper, so the keys
+// can't be used to guess codes offline.
+func (db *DB) RecoveryCodeKey(code string) string {
+	return db.hashRefreshToken("recovery_code:" + code)
+}
+
+// UseRecoveryCode removes a recovery code so it cannot be used again. key is
+// the code's RecoveryCodeKey, or the hash itself for a code from
+// User.RecoveryCodes. It returns ErrNotExist if the code has already been
+// used.
+func (db *DB) UseRecoveryCode(id int, key string) error {
+	return db.updateUser(id, func(user *User) error {
+		if _, ok := user.RecoveryCodeHashes[key]; ok {
+			delete(user.RecoveryCodeHashes, key)
+			return nil
+		}
+		for i, h := range user.RecoveryCodes {
+			if h == key {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Notification types.
+const (
+	NotificationFollow        = "follow"
+	NotificationFollowRequest = "follow_request"
+	NotificationFollowAccept  = "follow_accept"
+	NotificationMention       = "mention"
+	NotificationMessage       = "message"
+)
+
+// NotificationTypes lists every notification type.
+var NotificationTypes = []string{
+	NotificationFollow,
+	NotificationFollowRequest,
+	NotificationFollowAccept,
+	NotificationMention,
+	NotificationMessage,
+}
+
+// notificationTTL is how long notifications are kept.
+const notificationTTL = 90 * 24 * time.Hour
+
+// Notification tells UserID that ActorID did something involving them.
+// ChirpID and ConversationID are set for notifications about one.
+type Notification struct {
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	Type           string     `json:"type"`
+	ActorID        int        `json:"actor_id"`
+	ChirpID        int        `json:"chirp_id,omitempty"`
+	ConversationID int        `json:"conversation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+// GetNotifications returns up to limit of the user's notifications older
+// than the one with ID before, newest first. before 0 starts from the
+// newest. Notifications from users who have blocked the user, or whom they
+// blocked, are left out.
+func (db *DB) GetNotifications(userID, before, limit int, unreadOnly bool) ([]Notification, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []Notification{}
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID != userID || (before != 0 && notification.ID >= before) {
+			continue
+		}
+		if unreadOnly && notification.ReadAt != nil {
+			continue
+		}
+		if blockedEitherWay(dbStructure, userID, notification.ActorID) {
+			continue
+		}
+		if chirp, ok := dbStructure.Chirps[notification.ChirpID]; ok && chirp.expired(time.Now()) {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[j].ID < notifications[i].ID
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// CountUnreadNotifications returns how many notifications GetNotifications
+// would return with unreadOnly set and no limit.
+func (db *DB) CountUnreadNotifications(userID int) (int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID != userID || notification.ReadAt != nil || blockedEitherWay(dbStructure, userID, notification.ActorID) {
+			continue
+		}
+		if chirp, ok := dbStructure.Chirps[notification.ChirpID]; ok && chirp.expired(time.Now()) {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks the user's notifications with the given IDs
+// read, or all of them if ids is empty. IDs of other users' notifications
+// are ignored.
+func (db *DB) MarkNotificationsRead(userID int, ids []int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		mark := func(id int) {
+			notification, ok := dbStructure.Notifications[id]
+			if !ok || notification.UserID != userID || notification.ReadAt != nil {
+				return
+			}
+			notification.ReadAt = &now
+			dbStructure.Notifications[id] = notification
+		}
+		if len(ids) == 0 {
+			for id := range dbStructure.Notifications {
+				mark(id)
+			}
+		}
+		for _, id := range ids {
+			mark(id)
+		}
+		return nil
+	})
+}
+
+// SetNotificationPreferences turns notification types on or off for the
+// user. Types left out keep their setting.
+func (db *DB) SetNotificationPreferences(userID int, preferences map[string]bool) (User, error) {
+	var updated User
+	err := db.updateUser(userID, func(user *User) error {
+		if user.DisabledNotifications == nil {
+			user.DisabledNotifications = map[string]bool{}
+		}
+		for notificationType, enabled := range preferences {
+			if enabled {
+				delete(user.DisabledNotifications, notificationType)
+			} else {
+				user.DisabledNotifications[notificationType] = true
+			}
+		}
+		updated = *user
+		return nil
+	})
+	return updated, err
+}
+
+// notify records a notification unless the recipient turned the type off,
+// is the actor, or has blocked or muted the actor.
+func notify(dbStructure DBStructure, notification Notification) {
+	recipient, ok := dbStructure.Users[notification.UserID]
+	if !ok || notification.UserID == notification.ActorID || recipient.DisabledNotifications[notification.Type] {
+		return
+	}
+	if blockedEitherWay(dbStructure, notification.UserID, notification.ActorID) {
+		return
+	}
+	if _, muted := dbStructure.Mutes[relationKey(notification.UserID, notification.ActorID)]; muted {
+		return
+	}
+
+	id := 1
+	for existingID := range dbStructure.Notifications {
+		id = max(id, existingID+1)
+	}
+	notification.ID = id
+	notification.CreatedAt = time.Now().UTC()
+	dbStructure.Notifications[id] = notification
+}
+
+// removeNotifications deletes notifications matching the predicate.
+func removeNotifications(dbStructure DBStructure, match func(Notification) bool) {
+	for id, notification := range dbStructure.Notifications {
+		if match(notification) {
+			delete(dbStructure.Notifications, id)
+		}
+	}
+}
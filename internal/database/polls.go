@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrPollClosed    = errors.New("poll is closed")
+	ErrAlreadyVoted  = errors.New("already voted")
+	ErrInvalidOption = errors.New("invalid poll option")
+)
+
+// Poll is a question attached to a chirp. Each user can vote for one
+// option until ClosesAt.
+type Poll struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+	// Votes maps voters to the index of the option they picked.
+	Votes map[int]int `json:"votes,omitempty"`
+}
+
+// Closed reports whether voting has ended.
+func (poll Poll) Closed(now time.Time) bool {
+	return !poll.ClosesAt.After(now)
+}
+
+// Tally returns the number of votes for each option.
+func (poll Poll) Tally() []int {
+	tally := make([]int, len(poll.Options))
+	for _, option := range poll.Votes {
+		tally[option]++
+	}
+	return tally
+}
+
+// PollVote is a vote cast by a user.
+type PollVote struct {
+	ChirpID int `json:"chirp_id"`
+	Option  int `json:"option"`
+}
+
+// Vote records userID's vote for option in the poll on the chirp. Users
+// can only vote once, and only on polls in chirps they can see.
+func (db *DB) Vote(chirpID, userID, option int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.Poll == nil || !chirpVisible(*dbStructure, chirp, userID) {
+			return ErrNotExist
+		}
+		if chirp.Poll.Closed(time.Now()) {
+			return ErrPollClosed
+		}
+		if _, voted := chirp.Poll.Votes[userID]; voted {
+			return ErrAlreadyVoted
+		}
+		if option < 0 || option >= len(chirp.Poll.Options) {
+			return ErrInvalidOption
+		}
+
+		if chirp.Poll.Votes == nil {
+			chirp.Poll.Votes = map[int]int{}
+		}
+		chirp.Poll.Votes[userID] = option
+		dbStructure.Chirps[chirpID] = chirp
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetPollVotes returns the votes userID has cast, oldest chirp first.
+func (db *DB) GetPollVotes(userID int) ([]PollVote, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	votes := []PollVote{}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.Poll == nil {
+			continue
+		}
+		if option, ok := chirp.Poll.Votes[userID]; ok {
+			votes = append(votes, PollVote{ChirpID: chirp.ID, Option: option})
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return votes[i].ChirpID < votes[j].ChirpID
+	})
+	return votes, nil
+}
+
+// removeVotes deletes the user's poll votes.
+func removeVotes(dbStructure DBStructure, userID int) {
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.Poll != nil {
+			delete(chirp.Poll.Votes, userID)
+		}
+	}
+}
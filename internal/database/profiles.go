@@ -0,0 +1,227 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidHandle  = errors.New("handle must be 3 to 15 letters, digits or underscores and not only digits")
+	ErrReservedHandle = errors.New("handle is reserved")
+	ErrHandleTaken    = errors.New("handle is already taken")
+)
+
+const (
+	minHandleLength = 3
+	maxHandleLength = 15
+)
+
+// reservedHandles can't be claimed because they would clash with routes or
+// be mistaken for official accounts.
+var reservedHandles = map[string]bool{
+	"about":     true,
+	"admin":     true,
+	"api":       true,
+	"app":       true,
+	"chirpy":    true,
+	"deleted":   true,
+	"everyone":  true,
+	"help":      true,
+	"login":     true,
+	"logout":    true,
+	"me":        true,
+	"media":     true,
+	"moderator": true,
+	"null":      true,
+	"official":  true,
+	"root":      true,
+	"security":  true,
+	"settings":  true,
+	"signup":    true,
+	"staff":     true,
+	"support":   true,
+	"system":    true,
+	"undefined": true,
+}
+
+// NormalizeHandle lowercases the handle and drops a leading @.
+func NormalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+}
+
+// ValidateHandle checks a normalized handle against the handle rules.
+func ValidateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return ErrInvalidHandle
+	}
+	onlyDigits := true
+	for _, c := range handle {
+		switch {
+		case c >= 'a' && c <= 'z', c == '_':
+			onlyDigits = false
+		case c >= '0' && c <= '9':
+		default:
+			return ErrInvalidHandle
+		}
+	}
+	if onlyDigits {
+		return ErrInvalidHandle
+	}
+	if reservedHandles[handle] {
+		return ErrReservedHandle
+	}
+	return nil
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left
+// alone. Values must already be validated.
+type ProfileUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	Website     *string
+	Protected   *bool
+}
+
+// UpdateProfile applies the update, returning ErrHandleTaken if another
+// user has the handle.
+func (db *DB) UpdateProfile(id int, update ProfileUpdate) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if update.Handle != nil && *update.Handle != user.Handle {
+			if handleTaken(*dbStructure, *update.Handle) {
+				return ErrHandleTaken
+			}
+			user.Handle = *update.Handle
+		}
+		if update.DisplayName != nil {
+			user.DisplayName = *update.DisplayName
+		}
+		if update.Bio != nil {
+			user.Bio = *update.Bio
+		}
+		if update.Website != nil {
+			user.Website = *update.Website
+		}
+		if update.Protected != nil {
+			user.Protected = *update.Protected
+			// Opening an account up approves everyone waiting to follow it
+			if !user.Protected {
+				for key, follow := range dbStructure.Follows {
+					if follow.FolloweeID == id && follow.Pending {
+						follow.Pending = false
+						dbStructure.Follows[key] = follow
+					}
+				}
+			}
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUsersByHandles looks up users by normalized handle, skipping handles
+// nobody has.
+func (db *DB) GetUsersByHandles(handles []string) ([]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, handle := range handles {
+		wanted[handle] = true
+	}
+	users := []User{}
+	for _, user := range dbStructure.Users {
+		if wanted[user.Handle] && user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetUserByHandle looks up a user by normalized handle.
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range dbStructure.Users {
+		if user.Handle == handle {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrNotExist
+}
+
+func handleTaken(dbStructure DBStructure, handle string) bool {
+	for _, user := range dbStructure.Users {
+		if user.Handle == handle {
+			return true
+		}
+	}
+	return false
+}
+
+// generateHandle picks a free handle for a user who didn't choose one,
+// based on the local part of their email.
+func generateHandle(dbStructure DBStructure, email string, userID int) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	base := strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			return c
+		}
+		return -1
+	}, local)
+	if len(base) > maxHandleLength {
+		base = base[:maxHandleLength]
+	}
+	if ValidateHandle(base) != nil {
+		base = "user"
+	}
+	if ValidateHandle(base) == nil && !handleTaken(dbStructure, base) {
+		return base
+	}
+
+	for n := userID; ; n++ {
+		suffix := strconv.Itoa(n)
+		handle := base[:min(len(base), maxHandleLength-len(suffix))] + suffix
+		if !handleTaken(dbStructure, handle) {
+			return handle
+		}
+	}
+}
+
+// migrateHandles gives users created before handles existed a generated
+// one.
+func (db *DB) migrateHandles() error {
+	return db.update(func(dbStructure *DBStructure) error {
+		migrated := false
+		for id, user := range dbStructure.Users {
+			if user.Handle != "" {
+				continue
+			}
+			user.Handle = generateHandle(*dbStructure, user.Email, id)
+			dbStructure.Users[id] = user
+			migrated = true
+		}
+		if !migrated {
+			return errUnchanged
+		}
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package database
+
+import "time"
+
+// PurgeStats counts the records removed by PurgeExpired.
+type PurgeStats struct {
+	RefreshTokens       int
+	RevokedAccessTokens int
+	LoginAttempts       int
+	UsedTokens          int
+	Notifications       int
+	Chirps              int
+}
+
+// PurgeExpired deletes every record whose lifetime ended before now.
+func (db *DB) PurgeExpired(now time.Time) (PurgeStats, error) {
+	var stats PurgeStats
+	err := db.update(func(dbStructure *DBStructure) error {
+		stats = purgeExpired(*dbStructure, now)
+		if stats == (PurgeStats{}) {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return PurgeStats{}, err
+	}
+	return stats, nil
+}
+
+func purgeExpired(dbStructure DBStructure, now time.Time) PurgeStats {
+	stats := PurgeStats{}
+	for key, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.ExpiresAt.Before(now) {
+			delete(dbStructure.RefreshTokens, key)
+			stats.RefreshTokens++
+		}
+	}
+	for jti, expiresAt := range dbStructure.RevokedAccessTokens {
+		if expiresAt.Before(now) {
+			delete(dbStructure.RevokedAccessTokens, jti)
+			stats.RevokedAccessTokens++
+		}
+	}
+	for key, attempt := range dbStructure.LoginAttempts {
+		if attempt.ExpiresAt.Before(now) {
+			delete(dbStructure.LoginAttempts, key)
+			stats.LoginAttempts++
+		}
+	}
+	for jti, expiresAt := range dbStructure.UsedTokens {
+		if expiresAt.Before(now) {
+			delete(dbStructure.UsedTokens, jti)
+			stats.UsedTokens++
+		}
+	}
+
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.expired(now) {
+			deleteChirp(dbStructure, id)
+			stats.Chirps++
+		}
+	}
+
+	for id, notification := range dbStructure.Notifications {
+		if notification.CreatedAt.Add(notificationTTL).Before(now) {
+			delete(dbStructure.Notifications, id)
+			stats.Notifications++
+		}
+	}
+
+	return stats
+}
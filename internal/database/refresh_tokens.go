@@ -1,46 +1,144 @@
 package database
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that has already
+// been rotated is presented again. The whole token family is revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshToken is keyed in DBStructure.RefreshTokens by TokenHash; the raw
+// token is never persisted.
 type RefreshToken struct {
-	UserID    int       `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+	UserID    int    `json:"user_id"`
+	TokenHash string `json:"token_hash"`
+	FamilyID  string `json:"family_id"`
+	Rotated   bool   `json:"rotated"`
+	// AccessTokenID is the jti of the access token issued alongside this
+	// refresh token. It is denylisted when the token's family is revoked.
+	AccessTokenID string    `json:"access_token_id"`
+	UserAgent     string    `json:"user_agent"`
+	IP            string    `json:"ip"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastUsedAt    time.Time `json:"last_used_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
 }
 
-func (db *DB) SaveRefreshToken(userID int, token string) error {
-	dbStructure, err := db.loadDB()
+// Device describes the client a refresh token was issued to.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// SaveRefreshToken stores a refresh token as the first member of a new
+// token family and returns the family ID, which doubles as the session ID.
+func (db *DB) SaveRefreshToken(userID int, token, accessTokenID string, device Device) (string, error) {
+	familyID, err := newRandomID()
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	now := time.Now()
+	tokenHash := db.hashRefreshToken(token)
 	refreshToken := RefreshToken{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour),
+		UserID:        userID,
+		TokenHash:     tokenHash,
+		FamilyID:      familyID,
+		AccessTokenID: accessTokenID,
+		UserAgent:     device.UserAgent,
+		IP:            device.IP,
+		CreatedAt:     now,
+		LastUsedAt:    now,
+		ExpiresAt:     now.Add(time.Hour),
 	}
-	dbStructure.RefreshTokens[token] = refreshToken
-
-	err = db.writeDB(dbStructure)
+	err = db.update(func(dbStructure *DBStructure) error {
+		dbStructure.RefreshTokens[tokenHash] = refreshToken
+		return nil
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return familyID, nil
 }
 
-func (db *DB) RevokeRefreshToken(token string) error {
-	dbStructure, err := db.loadDB()
+// RotateRefreshToken exchanges oldToken for newToken within the same family
+// and returns the new token's record.
+// The old token stays on record as rotated so that a second use of it can be
+// detected; on reuse every token in the family is revoked and
+// ErrRefreshTokenReused is returned along with the old token's record.
+// The check and the rotation happen in one locked update, so two concurrent
+// uses of the same token can't both succeed.
+func (db *DB) RotateRefreshToken(oldToken, newToken, accessTokenID string, device Device) (RefreshToken, error) {
+	oldHash := db.hashRefreshToken(oldToken)
+	newHash := db.hashRefreshToken(newToken)
+
+	var refreshToken, rotated RefreshToken
+	reused := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		refreshToken, ok = dbStructure.RefreshTokens[oldHash]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now()
+		if refreshToken.ExpiresAt.Before(now) {
+			return ErrNotExist
+		}
+
+		if _, ok := dbStructure.Users[refreshToken.UserID]; !ok {
+			return ErrNotExist
+		}
+
+		if refreshToken.Rotated {
+			revokeFamily(*dbStructure, refreshToken.FamilyID)
+			reused = true
+			return nil
+		}
+
+		refreshToken.Rotated = true
+		dbStructure.RefreshTokens[oldHash] = refreshToken
+
+		rotated = RefreshToken{
+			UserID:        refreshToken.UserID,
+			TokenHash:     newHash,
+			FamilyID:      refreshToken.FamilyID,
+			AccessTokenID: accessTokenID,
+			UserAgent:     device.UserAgent,
+			IP:            device.IP,
+			CreatedAt:     refreshToken.CreatedAt,
+			LastUsedAt:    now,
+			ExpiresAt:     now.Add(time.Hour),
+		}
+		dbStructure.RefreshTokens[newHash] = rotated
+		return nil
+	})
 	if err != nil {
-		return err
+		return RefreshToken{}, err
 	}
-	delete(dbStructure.RefreshTokens, token)
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+	if reused {
+		return refreshToken, ErrRefreshTokenReused
 	}
-	return nil
+	return rotated, nil
+}
+
+// RevokeRefreshToken revokes the token and every other token in its family.
+func (db *DB) RevokeRefreshToken(token string) error {
+	tokenHash := db.hashRefreshToken(token)
+	return db.update(func(dbStructure *DBStructure) error {
+		refreshToken, ok := dbStructure.RefreshTokens[tokenHash]
+		if ok {
+			revokeFamily(*dbStructure, refreshToken.FamilyID)
+		}
+		revokeRefreshToken(*dbStructure, tokenHash)
+		return nil
+	})
 }
 
 func (db *DB) UserForRefreshToken(token string) (User, error) {
@@ -49,12 +147,12 @@ func (db *DB) UserForRefreshToken(token string) (User, error) {
 		return User{}, err
 	}
 
-	refreshToken, ok := dbStructure.RefreshTokens[token]
+	refreshToken, ok := dbStructure.RefreshTokens[db.hashRefreshToken(token)]
 	if !ok {
 		return User{}, ErrNotExist
 	}
 
-	if refreshToken.ExpiresAt.Before(time.Now()) {
+	if refreshToken.Rotated || refreshToken.ExpiresAt.Before(time.Now()) {
 		return User{}, ErrNotExist
 	}
 
@@ -65,3 +163,81 @@ func (db *DB) UserForRefreshToken(token string) (User, error) {
 
 	return user, nil
 }
+
+// hashRefreshToken returns the hex encoded HMAC-SHA256 of token keyed with
+// the server pepper.
+func (db *DB) hashRefreshToken(token string) string {
+	mac := hmac.New(sha256.New, db.tokenPepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// migrateRefreshTokens re-keys refresh tokens stored by older versions under
+// their raw value so that only the hash is kept on disk, and puts tokens
+// from before token families each in a family of their own, so that reuse
+// detection and session revocation cover them too.
+func (db *DB) migrateRefreshTokens() error {
+	return db.update(func(dbStructure *DBStructure) error {
+		migrated := false
+		for key, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.TokenHash != "" && refreshToken.FamilyID != "" {
+				continue
+			}
+			if refreshToken.FamilyID == "" {
+				familyID, err := newRandomID()
+				if err != nil {
+					return err
+				}
+				refreshToken.FamilyID = familyID
+			}
+			if refreshToken.CreatedAt.IsZero() {
+				refreshToken.CreatedAt = time.Now()
+				refreshToken.LastUsedAt = refreshToken.CreatedAt
+			}
+			delete(dbStructure.RefreshTokens, key)
+			if refreshToken.TokenHash == "" {
+				refreshToken.TokenHash = db.hashRefreshToken(key)
+			}
+			dbStructure.RefreshTokens[refreshToken.TokenHash] = refreshToken
+			migrated = true
+		}
+		if !migrated {
+			return errUnchanged
+		}
+		return nil
+	})
+}
+
+func revokeFamily(dbStructure DBStructure, familyID string) {
+	if familyID == "" {
+		return
+	}
+	for key, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.FamilyID == familyID {
+			revokeRefreshToken(dbStructure, key)
+		}
+	}
+}
+
+// revokeRefreshToken deletes the refresh token stored under key and
+// denylists the access token issued with it.
+func revokeRefreshToken(dbStructure DBStructure, key string) {
+	refreshToken, ok := dbStructure.RefreshTokens[key]
+	if !ok {
+		return
+	}
+	if refreshToken.AccessTokenID != "" {
+		dbStructure.RevokedAccessTokens[refreshToken.AccessTokenID] = refreshToken.ExpiresAt
+	}
+	delete(dbStructure.RefreshTokens, key)
+}
+
+// newRandomID returns a random 128-bit identifier as a hex string.
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
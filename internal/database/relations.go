@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Relation is one user blocking or muting another.
+type Relation struct {
+	UserID    int       `json:"user_id"`
+	TargetID  int       `json:"target_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func relationKey(userID, targetID int) string {
+	return fmt.Sprintf("%d:%d", userID, targetID)
+}
+
+// Block makes targetID unable to see userID's chirps and profile, and hides
+// theirs from userID. Follows between the two are removed. Blocking again is
+// a no-op.
+func (db *DB) Block(userID, targetID int) error {
+	return db.addRelation(func(s DBStructure) map[string]Relation { return s.Blocks }, userID, targetID, removeFollows)
+}
+
+// Unblock removes a block. It returns ErrNotExist if there was none.
+func (db *DB) Unblock(userID, targetID int) error {
+	return db.removeRelation(func(s DBStructure) map[string]Relation { return s.Blocks }, userID, targetID)
+}
+
+// Mute hides targetID's chirps from userID's chirp listings. Muting again
+// is a no-op.
+func (db *DB) Mute(userID, targetID int) error {
+	return db.addRelation(func(s DBStructure) map[string]Relation { return s.Mutes }, userID, targetID, nil)
+}
+
+// Unmute removes a mute. It returns ErrNotExist if there was none.
+func (db *DB) Unmute(userID, targetID int) error {
+	return db.removeRelation(func(s DBStructure) map[string]Relation { return s.Mutes }, userID, targetID)
+}
+
+// GetBlocks returns the users userID has blocked, newest first.
+func (db *DB) GetBlocks(userID int) ([]Relation, error) {
+	return db.getRelations(func(s DBStructure) map[string]Relation { return s.Blocks }, userID)
+}
+
+// GetMutes returns the users userID has muted, newest first.
+func (db *DB) GetMutes(userID int) ([]Relation, error) {
+	return db.getRelations(func(s DBStructure) map[string]Relation { return s.Mutes }, userID)
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (db *DB) IsBlocked(userID, otherID int) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	return blockedEitherWay(dbStructure, userID, otherID), nil
+}
+
+func blockedEitherWay(dbStructure DBStructure, userID, otherID int) bool {
+	_, blocked := dbStructure.Blocks[relationKey(userID, otherID)]
+	_, blockedBy := dbStructure.Blocks[relationKey(otherID, userID)]
+	return blocked || blockedBy
+}
+
+// addRelation records the relation and calls onAdd, if set, in the same
+// write.
+func (db *DB) addRelation(relations func(DBStructure) map[string]Relation, userID, targetID int, onAdd func(dbStructure DBStructure, userID, targetID int)) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[targetID]; !ok {
+			return ErrNotExist
+		}
+		key := relationKey(userID, targetID)
+		if _, ok := relations(*dbStructure)[key]; ok {
+			return errUnchanged
+		}
+		relations(*dbStructure)[key] = Relation{
+			UserID:    userID,
+			TargetID:  targetID,
+			CreatedAt: time.Now().UTC(),
+		}
+		if onAdd != nil {
+			onAdd(*dbStructure, userID, targetID)
+		}
+		return nil
+	})
+}
+
+func (db *DB) removeRelation(relations func(DBStructure) map[string]Relation, userID, targetID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := relationKey(userID, targetID)
+		if _, ok := relations(*dbStructure)[key]; !ok {
+			return ErrNotExist
+		}
+		delete(relations(*dbStructure), key)
+		return nil
+	})
+}
+
+func (db *DB) getRelations(relations func(DBStructure) map[string]Relation, userID int) ([]Relation, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Relation{}
+	for _, relation := range relations(dbStructure) {
+		if relation.UserID == userID {
+			result = append(result, relation)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[j].CreatedAt.Before(result[i].CreatedAt)
+	})
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import "time"
+
+// PublishScheduledChirps publishes the scheduled chirps due at now and
+// returns them. A chirp stops being scheduled in the same locked update
+// that sends its mention notifications, and no other write can restore an
+// older copy of it, so each is published exactly once.
+func (db *DB) PublishScheduledChirps(now time.Time) ([]Chirp, error) {
+	published := []Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, chirp := range dbStructure.Chirps {
+			if !chirp.Scheduled || chirp.PublishAt.After(now) {
+				continue
+			}
+			chirp.Scheduled = false
+			dbStructure.Chirps[id] = chirp
+			notifyMentions(*dbStructure, chirp)
+			published = append(published, chirp)
+		}
+		if len(published) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return published, nil
+}
+
+// NextScheduledChirp returns when the next scheduled chirp is due, and
+// false if none are scheduled.
+func (db *DB) NextScheduledChirp() (time.Time, bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var next time.Time
+	found := false
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.Scheduled && (!found || chirp.PublishAt.Before(next)) {
+			next = *chirp.PublishAt
+			found = true
+		}
+	}
+	return next, found, nil
+}
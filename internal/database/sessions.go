@@ -0,0 +1,84 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Session is the live token of a refresh token family, as shown to the
+// user who owns it.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// GetSessions returns the user's active sessions, most recently used first.
+func (db *DB) GetSessions(userID int) ([]Session, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []Session{}
+	for _, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.UserID != userID || refreshToken.Rotated || refreshToken.ExpiresAt.Before(now) {
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:         refreshToken.FamilyID,
+			UserAgent:  refreshToken.UserAgent,
+			IP:         refreshToken.IP,
+			CreatedAt:  refreshToken.CreatedAt,
+			LastUsedAt: refreshToken.LastUsedAt,
+			ExpiresAt:  refreshToken.ExpiresAt,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[j].LastUsedAt.Before(sessions[i].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession revokes every refresh token in the session. It returns
+// ErrNotExist if the session does not belong to the user.
+func (db *DB) RevokeSession(userID int, sessionID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		found := false
+		for _, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == userID && refreshToken.FamilyID == sessionID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrNotExist
+		}
+
+		revokeFamily(*dbStructure, sessionID)
+		return nil
+	})
+}
+
+// RevokeUserSessions revokes all of the user's sessions except keepSessionID,
+// which may be empty to log the user out everywhere.
+func (db *DB) RevokeUserSessions(userID int, keepSessionID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for key, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID != userID {
+				continue
+			}
+			if keepSessionID != "" && refreshToken.FamilyID == keepSessionID {
+				continue
+			}
+			revokeRefreshToken(*dbStructure, key)
+		}
+		return nil
+	})
+}
@@ -2,32 +2,87 @@ package database
 
 import (
 	"errors"
+	"time"
+)
+
+// User roles.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
 type User struct {
-	ID             int          `json:"id"`
-	Email          string       `json:"email"`
-	HashedPassword string       `json:"hashed_password"`
-	RefreshToken   RefreshToken `json:"refresh_token"`
-	IsChirpyRed    bool         `json:"is_chirpy_red"`
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	Website     string    `json:"website,omitempty"`
+	Avatar      *MediaRef `json:"avatar,omitempty"`
+	// Protected accounts approve their followers, and only followers see
+	// their chirps.
+	Protected bool `json:"protected,omitempty"`
+	// DisabledNotifications holds the notification types the user turned
+	// off.
+	DisabledNotifications map[string]bool `json:"disabled_notifications,omitempty"`
+	HashedPassword        string          `json:"hashed_password"`
+	IsChirpyRed           bool            `json:"is_chirpy_red"`
+	Role                  string          `json:"role"`
+	EmailVerified         bool            `json:"email_verified"`
+	// PendingEmail is the address the user asked to change to, waiting to
+	// be confirmed from that address.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// TokensValidAfter is the watermark before which access tokens issued to
+	// the user are rejected. It is nil until the first invalidation.
+	TokensValidAfter *time.Time `json:"tokens_valid_after,omitempty"`
+	// TOTPSecret is set while enrolling and kept once TOTPEnabled.
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"totp_last_step,omitempty"`
+	// RecoveryCodeHashes maps the RecoveryCodeKey of each unused recovery
+	// code to its hash, so a code is checked against one hash at most.
+	RecoveryCodeHashes map[string]string `json:"recovery_code_hashes,omitempty"`
+	// RecoveryCodes holds the hashes of unused recovery codes issued before
+	// RecoveryCodeHashes, which have no key.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// DeletedAt is set while the account is pending deletion.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
 
-func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	newID := len(dbStructure.Users) + 1
-	user := User{
-		ID:             newID,
-		Email:          email,
-		HashedPassword: hashedPassword,
-		IsChirpyRed:    false, // defaulting to false
-	}
-	dbStructure.Users[newID] = user
-	err = db.writeDB(dbStructure)
+var ErrInvalidRole = errors.New("invalid role")
+
+// CreateUser creates a user with the given normalized handle, or a
+// generated one if handle is empty. It returns ErrAlreadyExists if another
+// user has the email.
+func (db *DB) CreateUser(email, hashedPassword, handle string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, other := range dbStructure.Users {
+			if other.Email == email {
+				return ErrAlreadyExists
+			}
+		}
+		newID := dbStructure.NextUserID
+		dbStructure.NextUserID++
+		if handle == "" {
+			handle = generateHandle(*dbStructure, email, newID)
+		} else if handleTaken(*dbStructure, handle) {
+			return ErrHandleTaken
+		}
+		user = User{
+			ID:             newID,
+			Email:          email,
+			Handle:         handle,
+			HashedPassword: hashedPassword,
+			IsChirpyRed:    false, // defaulting to false
+			Role:           RoleUser,
+		}
+		dbStructure.Users[newID] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -64,25 +119,126 @@ func (db *DB) GetUserByEmail(email string) (User, error) {
 }
 
 func (db *DB) UpdateUser(id int, email, hashedPassword string, isChirpyRed bool) (User, error) {
-	dbStructure, err := db.loadDB()
+	var updated User
+	err := db.updateUser(id, func(user *User) error {
+		if user.Email != email {
+			user.EmailVerified = false
+		}
+		user.Email = email
+		user.HashedPassword = hashedPassword
+		user.IsChirpyRed = isChirpyRed
+		updated = *user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
+	return updated, nil
+}
+
+// GetUsers returns every user.
+func (db *DB) GetUsers() ([]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(dbStructure.Users))
+	for _, user := range dbStructure.Users {
+		users = append(users, user)
 	}
 
-	user.Email = email
-	user.HashedPassword = hashedPassword
-	user.IsChirpyRed = isChirpyRed
-	dbStructure.Users[id] = user
+	return users, nil
+}
+
+// SetUserRole changes the user's role. It returns ErrInvalidRole for roles
+// other than RoleUser, RoleModerator and RoleAdmin.
+func (db *DB) SetUserRole(id int, role string) (User, error) {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+	default:
+		return User{}, ErrInvalidRole
+	}
 
-	err = db.writeDB(dbStructure)
+	var updated User
+	err := db.updateUser(id, func(user *User) error {
+		user.Role = role
+		updated = *user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	return user, nil
+	return updated, nil
+}
+
+// UpdatePasswordHash replaces the user's stored password hash.
+func (db *DB) UpdatePasswordHash(id int, hashedPassword string) error {
+	return db.updateUser(id, func(user *User) error {
+		user.HashedPassword = hashedPassword
+		return nil
+	})
+}
+
+// VerifyEmail marks the user's email as verified if it is still email. It
+// returns ErrNotExist if the user has since changed address.
+func (db *DB) VerifyEmail(id int, email string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.Email != email {
+			return ErrNotExist
+		}
+		user.EmailVerified = true
+		return nil
+	})
+}
+
+// SetPendingEmail records an email change awaiting confirmation.
+func (db *DB) SetPendingEmail(id int, email string) error {
+	return db.updateUser(id, func(user *User) error {
+		user.PendingEmail = email
+		return nil
+	})
+}
+
+// ConfirmEmailChange switches the user to their pending email, which counts
+// as verified. It returns ErrNotExist if email is not the pending address
+// and ErrAlreadyExists if another user has taken it in the meantime.
+func (db *DB) ConfirmEmailChange(id int, email string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok || user.PendingEmail == "" || user.PendingEmail != email {
+			return ErrNotExist
+		}
+		for _, other := range dbStructure.Users {
+			if other.ID != id && other.Email == email {
+				return ErrAlreadyExists
+			}
+		}
+
+		user.Email = email
+		user.EmailVerified = true
+		user.PendingEmail = ""
+		dbStructure.Users[id] = user
+		return nil
+	})
+}
+
+// updateUser applies update to the user in a single locked update, writing
+// the result back unless update fails.
+func (db *DB) updateUser(id int, update func(*User) error) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		err := update(&user)
+		if err != nil {
+			return err
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 }
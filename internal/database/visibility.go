@@ -0,0 +1,125 @@
+package database
+
+import "time"
+
+// Chirp visibility levels. Chirps stored before visibility existed have
+// none and are public.
+const (
+	// VisibilityPublic chirps are listed for everyone.
+	VisibilityPublic = "public"
+	// VisibilityFollowers chirps are only shown to the author's followers
+	// and the users they mention.
+	VisibilityFollowers = "followers"
+	// VisibilityUnlisted chirps can be seen by anyone with a link but are
+	// left out of listings other than the author's own chirps.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityDirect chirps are only shown to the users they mention.
+	VisibilityDirect = "direct"
+)
+
+// ValidVisibility reports whether v is a visibility level.
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPublic, VisibilityFollowers, VisibilityUnlisted, VisibilityDirect:
+		return true
+	}
+	return false
+}
+
+// Every chirp read made on behalf of a user goes through chirpVisible so
+// that the same rules apply to all of them. viewerID is 0 for anonymous
+// requests.
+
+// GetChirpsForViewer returns the chirps viewerID may see in a listing,
+// leaving out those by users they muted. With authorID set only that
+// author's chirps are returned, including unlisted ones.
+func (db *DB) GetChirpsForViewer(viewerID, authorID int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if authorID != 0 && chirp.AuthorID != authorID {
+			continue
+		}
+		if !chirpVisible(dbStructure, chirp, viewerID) {
+			continue
+		}
+		if authorID == 0 && chirp.AuthorID != viewerID && chirp.Visibility == VisibilityUnlisted {
+			continue
+		}
+		if _, muted := dbStructure.Mutes[relationKey(viewerID, chirp.AuthorID)]; muted {
+			continue
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, nil
+}
+
+// GetChirpForViewer returns the chirp if viewerID may see it, and
+// ErrNotExist otherwise. Mutes don't apply to chirps looked up directly.
+func (db *DB) GetChirpForViewer(id, viewerID int) (Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[id]
+	if !ok || !chirpVisible(dbStructure, chirp, viewerID) {
+		return Chirp{}, ErrNotExist
+	}
+
+	return chirp, nil
+}
+
+// chirpVisible reports whether viewerID may see the chirp.
+func chirpVisible(dbStructure DBStructure, chirp Chirp, viewerID int) bool {
+	if authorPendingDeletion(dbStructure, chirp) || chirp.expired(time.Now()) {
+		return false
+	}
+	if viewerID != 0 && viewerID == chirp.AuthorID {
+		return true
+	}
+	if chirp.Scheduled {
+		return false
+	}
+	if viewerID != 0 && blockedEitherWay(dbStructure, viewerID, chirp.AuthorID) {
+		return false
+	}
+
+	switch effectiveVisibility(dbStructure, chirp) {
+	case VisibilityFollowers:
+		return viewerID != 0 && (isFollower(dbStructure, viewerID, chirp.AuthorID) || mentions(chirp, viewerID))
+	case VisibilityDirect:
+		return viewerID != 0 && mentions(chirp, viewerID)
+	}
+	return true
+}
+
+// effectiveVisibility is the chirp's visibility, narrowed to followers-only
+// when the author's account is protected.
+func effectiveVisibility(dbStructure DBStructure, chirp Chirp) string {
+	visibility := chirp.Visibility
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	if visibility == VisibilityDirect {
+		return visibility
+	}
+	if author, ok := dbStructure.Users[chirp.AuthorID]; ok && author.Protected {
+		return VisibilityFollowers
+	}
+	return visibility
+}
+
+func mentions(chirp Chirp, userID int) bool {
+	for _, id := range chirp.MentionIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
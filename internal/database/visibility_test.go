@@ -0,0 +1,104 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChirpVisible(t *testing.T) {
+	const (
+		anonymous = 0
+		author    = 1
+		follower  = 2
+		stranger  = 3
+		mentioned = 4
+		requester = 5
+		blocked   = 6
+		protected = 7
+		deleted   = 8
+	)
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	dbStructure := DBStructure{
+		Users: map[int]User{
+			author:    {ID: author},
+			follower:  {ID: follower},
+			stranger:  {ID: stranger},
+			mentioned: {ID: mentioned},
+			requester: {ID: requester},
+			blocked:   {ID: blocked},
+			protected: {ID: protected, Protected: true},
+			deleted:   {ID: deleted, DeletedAt: &past},
+		},
+		Follows: map[string]Follow{
+			relationKey(follower, author):     {FollowerID: follower, FolloweeID: author},
+			relationKey(follower, protected):  {FollowerID: follower, FolloweeID: protected},
+			relationKey(requester, author):    {FollowerID: requester, FolloweeID: author, Pending: true},
+			relationKey(requester, protected): {FollowerID: requester, FolloweeID: protected, Pending: true},
+		},
+		Blocks: map[string]Relation{
+			relationKey(author, blocked): {UserID: author, TargetID: blocked},
+		},
+	}
+
+	public := Chirp{AuthorID: author}
+	unlisted := Chirp{AuthorID: author, Visibility: VisibilityUnlisted}
+	followers := Chirp{AuthorID: author, Visibility: VisibilityFollowers, MentionIDs: []int{mentioned}}
+	direct := Chirp{AuthorID: author, Visibility: VisibilityDirect, MentionIDs: []int{mentioned}}
+	scheduled := Chirp{AuthorID: author, Scheduled: true, PublishAt: &future}
+	expired := Chirp{AuthorID: author, ExpiresAt: &past}
+	expiring := Chirp{AuthorID: author, ExpiresAt: &future}
+	protectedPublic := Chirp{AuthorID: protected}
+	protectedDirect := Chirp{AuthorID: protected, Visibility: VisibilityDirect, MentionIDs: []int{mentioned}}
+	deletedPublic := Chirp{AuthorID: deleted}
+	anonymized := Chirp{AuthorID: 0}
+
+	tests := []struct {
+		name   string
+		chirp  Chirp
+		viewer int
+		want   bool
+	}{
+		{"public to anonymous", public, anonymous, true},
+		{"public to stranger", public, stranger, true},
+		{"public to author", public, author, true},
+		{"public to blocked user", public, blocked, false},
+		{"unlisted to anonymous", unlisted, anonymous, true},
+		{"unlisted to blocked user", unlisted, blocked, false},
+		{"followers-only to follower", followers, follower, true},
+		{"followers-only to mentioned user", followers, mentioned, true},
+		{"followers-only to pending follower", followers, requester, false},
+		{"followers-only to stranger", followers, stranger, false},
+		{"followers-only to anonymous", followers, anonymous, false},
+		{"followers-only to author", followers, author, true},
+		{"direct to mentioned user", direct, mentioned, true},
+		{"direct to follower", direct, follower, false},
+		{"direct to anonymous", direct, anonymous, false},
+		{"direct to author", direct, author, true},
+		{"scheduled to author", scheduled, author, true},
+		{"scheduled to follower", scheduled, follower, false},
+		{"expired to author", expired, author, false},
+		{"expired to anonymous", expired, anonymous, false},
+		{"expiring later to anonymous", expiring, anonymous, true},
+		{"protected author to follower", protectedPublic, follower, true},
+		{"protected author to pending follower", protectedPublic, requester, false},
+		{"protected author to anonymous", protectedPublic, anonymous, false},
+		{"protected author to author", protectedPublic, protected, true},
+		{"protected author direct to mentioned user", protectedDirect, mentioned, true},
+		{"protected author direct to follower", protectedDirect, follower, false},
+		{"author pending deletion to anonymous", deletedPublic, anonymous, false},
+		{"author pending deletion to author", deletedPublic, deleted, false},
+		{"anonymized to anonymous", anonymized, anonymous, true},
+		{"anonymized to stranger", anonymized, stranger, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chirpVisible(dbStructure, tt.chirp, tt.viewer)
+			if got != tt.want {
+				t.Errorf("chirpVisible = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package media
+
+import "encoding/binary"
+
+// maxGIFFrames caps the frames of an animated GIF. Together with maxPixels,
+// which applies to the frames' combined area, it bounds the memory
+// gif.DecodeAll needs, since every frame is decoded at once.
+const maxGIFFrames = 500
+
+// checkGIFFrames walks the blocks of a GIF without decoding any pixels and
+// returns ErrTooManyFrames or ErrTooManyPixels as soon as the frames exceed
+// maxGIFFrames or their combined area exceeds maxPixels.
+func checkGIFFrames(data []byte) error {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return ErrInvalidImage
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	frames, pixels := 0, 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21:
+			// Extension: label, then data sub-blocks
+			i += 2
+		case 0x2C:
+			// Image descriptor, optional local color table, LZW minimum
+			// code size, then data sub-blocks
+			if i+10 > len(data) {
+				return ErrInvalidImage
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			frames++
+			pixels += width * height
+			if frames > maxGIFFrames {
+				return ErrTooManyFrames
+			}
+			if pixels > maxPixels {
+				return ErrTooManyPixels
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i++
+		case 0x3B:
+			return nil
+		default:
+			return ErrInvalidImage
+		}
+
+		// Skip the sub-blocks up to the zero-length terminator
+		for {
+			if i >= len(data) {
+				return ErrInvalidImage
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	return ErrInvalidImage
+}
@@ -0,0 +1,108 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// gifBuilder writes GIF blocks by hand, so frames can claim any size
+// without their pixels being encoded.
+type gifBuilder struct {
+	bytes.Buffer
+}
+
+func newGIF(globalColorTable bool) *gifBuilder {
+	b := &gifBuilder{}
+	b.WriteString("GIF89a")
+	var packed byte
+	if globalColorTable {
+		// Four entries
+		packed = 0x80 | 0x01
+	}
+	b.Write([]byte{1, 0, 1, 0, packed, 0, 0})
+	if globalColorTable {
+		b.Write(make([]byte, 3*4))
+	}
+	return b
+}
+
+func (b *gifBuilder) extension() *gifBuilder {
+	// Graphic control extension
+	b.Write([]byte{0x21, 0xF9, 4, 0, 0, 0, 0, 0})
+	return b
+}
+
+func (b *gifBuilder) frame(width, height int, localColorTable bool) *gifBuilder {
+	b.WriteByte(0x2C)
+	b.Write([]byte{0, 0, 0, 0})
+	binary.Write(b, binary.LittleEndian, uint16(width))
+	binary.Write(b, binary.LittleEndian, uint16(height))
+	if localColorTable {
+		// Two entries
+		b.WriteByte(0x80)
+		b.Write(make([]byte, 3*2))
+	} else {
+		b.WriteByte(0)
+	}
+	// LZW minimum code size, one data sub-block and the terminator
+	b.Write([]byte{2, 2, 0x4C, 0x01, 0})
+	return b
+}
+
+func (b *gifBuilder) frames(n, width, height int) *gifBuilder {
+	for i := 0; i < n; i++ {
+		b.frame(width, height, false)
+	}
+	return b
+}
+
+func (b *gifBuilder) trailer() []byte {
+	b.WriteByte(0x3B)
+	return b.Bytes()
+}
+
+func TestCheckGIFFrames(t *testing.T) {
+	encoded := &bytes.Buffer{}
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	err := gif.EncodeAll(encoded, &gif.GIF{
+		Image: []*image.Paletted{frame, frame, frame},
+		Delay: []int{10, 10, 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"encoded by image/gif", encoded.Bytes(), nil},
+		{"no frames", newGIF(false).trailer(), nil},
+		{"one frame", newGIF(true).frame(1, 1, false).trailer(), nil},
+		{"extensions and local color tables", newGIF(true).extension().frame(1, 1, true).extension().frame(1, 1, false).trailer(), nil},
+		{"frame limit", newGIF(false).frames(maxGIFFrames, 1, 1).trailer(), nil},
+		{"too many frames", newGIF(false).frames(maxGIFFrames+1, 1, 1).trailer(), ErrTooManyFrames},
+		{"one large frame", newGIF(false).frame(65535, 65535, false).trailer(), ErrTooManyPixels},
+		// Each frame is under maxPixels but together they are over it
+		{"large frames", newGIF(false).frames(3, 5000, 4000).trailer(), ErrTooManyPixels},
+		{"too short", []byte("GIF89a"), ErrInvalidImage},
+		{"missing trailer", newGIF(false).frame(1, 1, false).Bytes(), ErrInvalidImage},
+		{"truncated descriptor", newGIF(false).Bytes()[:13+1], ErrInvalidImage},
+		{"truncated sub-blocks", newGIF(false).frame(1, 1, false).Bytes()[:13+14], ErrInvalidImage},
+		{"unknown block", append(newGIF(false).Bytes(), 0x00), ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGIFFrames(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("checkGIFFrames = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type: use JPEG, PNG or GIF")
+	ErrInvalidImage    = errors.New("image could not be decoded")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+	ErrTooManyFrames   = errors.New("animation has too many frames")
+)
+
+// maxPixels caps decoded image size so a small, highly compressed upload
+// can't exhaust memory.
+const maxPixels = 40_000_000
+
+const jpegQuality = 85
+
+// extensions maps the content types we accept to the extension they are
+// stored under.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// File is an encoded image ready to be stored. Name is its content address.
+type File struct {
+	Name        string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Processed is a sanitized upload and its thumbnail.
+type Processed struct {
+	Original  File
+	Thumbnail File
+}
+
+// Process sniffs the type of an uploaded image, decodes it and encodes it
+// again. Re-encoding drops EXIF and any other metadata; JPEG orientation is
+// applied to the pixels first so photos stay upright. Images larger than
+// maxSize on either side are scaled down to fit, and the thumbnail fits in
+// thumbSize. Animated GIFs keep their frames but aren't scaled.
+func Process(data []byte, maxSize, thumbSize int) (Processed, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := extensions[contentType]; !ok {
+		return Processed{}, ErrUnsupportedType
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != contentType {
+		return Processed{}, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return Processed{}, ErrTooManyPixels
+	}
+
+	var original File
+	var img image.Image
+	if contentType == "image/gif" {
+		err = checkGIFFrames(data)
+		if err != nil {
+			return Processed{}, err
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return Processed{}, ErrInvalidImage
+		}
+		if len(anim.Image) > 1 {
+			buf := &bytes.Buffer{}
+			err = gif.EncodeAll(buf, &gif.GIF{
+				Image:     anim.Image,
+				Delay:     anim.Delay,
+				LoopCount: anim.LoopCount,
+				Disposal:  anim.Disposal,
+				Config:    anim.Config,
+			})
+			if err != nil {
+				return Processed{}, err
+			}
+			original = newFile(buf.Bytes(), contentType, anim.Config.Width, anim.Config.Height)
+		}
+		img = anim.Image[0]
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Processed{}, ErrInvalidImage
+		}
+		if contentType == "image/jpeg" {
+			img = orient(img, jpegOrientation(data))
+		}
+	}
+
+	if original.Data == nil {
+		original, err = encode(fit(img, maxSize), contentType)
+		if err != nil {
+			return Processed{}, err
+		}
+	}
+
+	// Thumbnails are JPEG unless the image may be transparent
+	thumbType := "image/jpeg"
+	if contentType != "image/jpeg" && !isOpaque(img) {
+		thumbType = "image/png"
+	}
+	thumbnail, err := encode(fit(img, thumbSize), thumbType)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	return Processed{
+		Original:  original,
+		Thumbnail: thumbnail,
+	}, nil
+}
+
+func encode(img image.Image, contentType string) (File, error) {
+	buf := &bytes.Buffer{}
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		err = png.Encode(buf, img)
+	case "image/gif":
+		err = gif.Encode(buf, img, nil)
+	default:
+		err = ErrUnsupportedType
+	}
+	if err != nil {
+		return File{}, err
+	}
+	bounds := img.Bounds()
+	return newFile(buf.Bytes(), contentType, bounds.Dx(), bounds.Dy()), nil
+}
+
+func newFile(data []byte, contentType string, width, height int) File {
+	sum := sha256.Sum256(data)
+	return File{
+		Name:        hex.EncodeToString(sum[:]) + extensions[contentType],
+		ContentType: contentType,
+		Width:       width,
+		Height:      height,
+		Data:        data,
+	}
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
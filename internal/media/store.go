@@ -0,0 +1,68 @@
+package media
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// validName matches the content addresses made by Process.
+var validName = regexp.MustCompile(`^[0-9a-f]{64}\.(jpg|png|gif)$`)
+
+// Store keeps files in Dir under their content address. Since a name always
+// refers to the same bytes, saving a file that already exists is a no-op.
+type Store struct {
+	Dir string
+}
+
+// Save writes f to the store unless it's already there.
+func (s Store) Save(f File) error {
+	path := filepath.Join(s.Dir, f.Name)
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(f.Data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Path returns where the named file is stored, or false if name isn't a
+// valid content address.
+func (s Store) Path(name string) (string, bool) {
+	if !validName.MatchString(name) {
+		return "", false
+	}
+	return filepath.Join(s.Dir, name), true
+}
+
+// ContentType returns the content type of a stored file from its name.
+func ContentType(name string) string {
+	for contentType, ext := range extensions {
+		if filepath.Ext(name) == ext {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const defaultJanitorInterval = 10 * time.Minute
+
+// janitorMetrics is updated by the janitor goroutine and read by
+// handlerMetrics.
+type janitorMetrics struct {
+	runs                atomic.Int64
+	refreshTokens       atomic.Int64
+	revokedAccessTokens atomic.Int64
+	loginAttempts       atomic.Int64
+	usedTokens          atomic.Int64
+	notifications       atomic.Int64
+	chirps              atomic.Int64
+	deletedUsers        atomic.Int64
+	exports             atomic.Int64
+	media               atomic.Int64
+}
+
+// runJanitor purges expired records at startup and then every interval
+// until ctx is cancelled, so a server restarted more often than interval
+// still cleans up.
+func (cfg *apiConfig) runJanitor(ctx context.Context, interval time.Duration) {
+	cfg.purgeExpired()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg.purgeExpired()
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeExpired() {
+	stats, err := cfg.DB.PurgeExpired(time.Now())
+	if err != nil {
+		log.Printf("Janitor: couldn't purge expired records: %s", err)
+		return
+	}
+
+	cfg.janitor.runs.Add(1)
+	cfg.janitor.refreshTokens.Add(int64(stats.RefreshTokens))
+	cfg.janitor.revokedAccessTokens.Add(int64(stats.RevokedAccessTokens))
+	cfg.janitor.loginAttempts.Add(int64(stats.LoginAttempts))
+	cfg.janitor.usedTokens.Add(int64(stats.UsedTokens))
+	cfg.janitor.notifications.Add(int64(stats.Notifications))
+	cfg.janitor.chirps.Add(int64(stats.Chirps))
+	if stats != (database.PurgeStats{}) {
+		log.Printf(
+			"Janitor: purged %d expired refresh tokens, %d denylisted access tokens, %d login attempt records, %d used tokens, %d old notifications and %d expired chirps",
+			stats.RefreshTokens,
+			stats.RevokedAccessTokens,
+			stats.LoginAttempts,
+			stats.UsedTokens,
+			stats.Notifications,
+			stats.Chirps,
+		)
+	}
+
+	exports := cfg.purgeExpiredExports(time.Now())
+	cfg.janitor.exports.Add(int64(exports))
+	if exports > 0 {
+		log.Printf("Janitor: purged %d expired exports", exports)
+	}
+
+	media := cfg.purgeOrphanedMedia(time.Now())
+	cfg.janitor.media.Add(int64(media))
+	if media > 0 {
+		log.Printf("Janitor: purged %d orphaned images", media)
+	}
+
+	if cfg.accountDeletionGrace == 0 {
+		return
+	}
+	purged, err := cfg.DB.PurgeDeletedUsers(time.Now().Add(-cfg.accountDeletionGrace), cfg.deletedUserChirps)
+	if err != nil {
+		log.Printf("Janitor: couldn't purge deleted users: %s", err)
+		return
+	}
+	cfg.janitor.deletedUsers.Add(int64(purged))
+	if purged > 0 {
+		log.Printf("Janitor: purged %d deleted users", purged)
+	}
+}
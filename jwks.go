@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+)
+
+// loadJWTKeys builds the access token keyring. JWT_SECRET, when set, is kept
+// as the HS256 key "default" so tokens issued before the keyring existed stay
+// valid. JWT_KEYRING_FILE, when set, adds its keys and picks the signing key.
+func loadJWTKeys() (*auth.Keyring, error) {
+	keys := auth.NewKeyring()
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret != "" {
+		err := keys.Add(auth.Key{
+			ID:        auth.DefaultKeyID,
+			Algorithm: auth.AlgHS256,
+			Secret:    []byte(jwtSecret),
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = keys.SetSigningKey(auth.DefaultKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keyringFile := os.Getenv("JWT_KEYRING_FILE")
+	if keyringFile != "" {
+		return keys, keys.LoadFile(keyringFile)
+	}
+
+	if jwtSecret == "" {
+		return nil, errors.New("neither JWT_SECRET nor JWT_KEYRING_FILE environment variable is set")
+	}
+	return keys, nil
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	// Failures allowed before backoff starts, per account and per IP. The IP
+	// limit is higher since many users can share an address.
+	accountFreeFailures = 3
+	ipFreeFailures      = 20
+
+	loginBackoffBase = time.Second
+	loginLockoutMax  = 15 * time.Minute
+)
+
+func accountLoginKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBackoff returns the exponential backoff function used for a key
+// that may fail freeFailures times without delay. The delay doubles with
+// each further failure until it reaches loginLockoutMax.
+func loginBackoff(freeFailures int) func(failures int) time.Duration {
+	return func(failures int) time.Duration {
+		excess := failures - freeFailures
+		if excess <= 0 {
+			return 0
+		}
+		if excess > 20 {
+			return loginLockoutMax
+		}
+		return min(loginBackoffBase<<(excess-1), loginLockoutMax)
+	}
+}
+
+// reserveLoginAttempt counts a login attempt as failed against both the
+// account and the client IP before the credentials are checked, so that
+// parallel guesses are held to the lockout. It responds with 429 and
+// returns false if either key is locked out. Callers release the attempt
+// with releaseLoginAttempt once the credentials check out.
+func (cfg *apiConfig) reserveLoginAttempt(w http.ResponseWriter, accountKey, ipKey string) bool {
+	attempt, err := cfg.DB.ReserveLoginAttempt(accountKey, loginBackoff(accountFreeFailures))
+	if err == nil {
+		attempt, err = cfg.DB.ReserveLoginAttempt(ipKey, loginBackoff(ipFreeFailures))
+		if err != nil {
+			releaseErr := cfg.DB.ReleaseLoginAttempt(accountKey, loginBackoff(accountFreeFailures))
+			if releaseErr != nil {
+				log.Printf("Couldn't release login attempt for %s: %s", accountKey, releaseErr)
+			}
+		}
+	}
+	if errors.Is(err, database.ErrLoginLocked) {
+		wait := time.Until(attempt.LockedUntil)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return false
+	}
+	return true
+}
+
+// releaseLoginAttempt takes back an attempt reserved by reserveLoginAttempt
+// whose credentials were correct.
+func (cfg *apiConfig) releaseLoginAttempt(accountKey, ipKey string) error {
+	err := cfg.DB.ReleaseLoginAttempt(accountKey, loginBackoff(accountFreeFailures))
+	if err != nil {
+		return err
+	}
+	return cfg.DB.ReleaseLoginAttempt(ipKey, loginBackoff(ipFreeFailures))
+}
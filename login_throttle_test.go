@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		freeFailures int
+		failures     int
+		want         time.Duration
+	}{
+		{accountFreeFailures, 0, 0},
+		{accountFreeFailures, 3, 0},
+		{accountFreeFailures, 4, time.Second},
+		{accountFreeFailures, 5, 2 * time.Second},
+		{accountFreeFailures, 6, 4 * time.Second},
+		{accountFreeFailures, 12, 256 * time.Second},
+		{accountFreeFailures, 13, 512 * time.Second},
+		{accountFreeFailures, 14, loginLockoutMax},
+		{accountFreeFailures, 23, loginLockoutMax},
+		{accountFreeFailures, 24, loginLockoutMax},
+		// Large counts must not overflow the shift
+		{accountFreeFailures, 100, loginLockoutMax},
+		{ipFreeFailures, 20, 0},
+		{ipFreeFailures, 21, time.Second},
+		{ipFreeFailures, 25, 16 * time.Second},
+	}
+	for _, tt := range tests {
+		got := loginBackoff(tt.freeFailures)(tt.failures)
+		if got != tt.want {
+			t.Errorf("loginBackoff(%d)(%d) = %s, want %s", tt.freeFailures, tt.failures, got, tt.want)
+		}
+	}
+}
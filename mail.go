@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/mailer"
+)
+
+// loadMailer sends through SMTP_ADDR when it is set, authenticating with
+// SMTP_USERNAME and SMTP_PASSWORD. Otherwise mail is written to
+// MAIL_LOG_FILE, or to the log if that is unset too.
+func loadMailer() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@localhost>"
+	}
+
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return &mailer.LogMailer{
+			Path: os.Getenv("MAIL_LOG_FILE"),
+			From: from,
+		}
+	}
+	return mailer.NewSMTPMailer(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+}
+
+// sendMail sends msg in the background so slow mail servers don't hold up
+// requests or reveal whether an address has an account. Shutdown waits for
+// pending mail.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	cfg.mailWG.Add(1)
+	go func() {
+		defer cfg.mailWG.Done()
+		err := cfg.mailer.Send(msg)
+		if err != nil {
+			log.Printf("Couldn't send mail to %s: %s", msg.To, err)
+		}
+	}()
+}
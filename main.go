@@ -1,19 +1,54 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
 	"github.com/TedMartell/ChirpyServerProject/internal/database"
+	"github.com/TedMartell/ChirpyServerProject/internal/mailer"
+	"github.com/TedMartell/ChirpyServerProject/internal/media"
 	"github.com/joho/godotenv"
 )
 
 type apiConfig struct {
 	fileserverHits int
 	DB             *database.DB
-	jwtSecret      string
+	jwtKeys        *auth.Keyring
+	argon2Params   auth.Argon2Params
+	passwordPolicy auth.PasswordPolicy
+	// dummyPasswordHash is checked against when logging in with an
+	// unknown email so the response time doesn't reveal which emails exist.
+	dummyPasswordHash string
+	mailer            mailer.Mailer
+	mailWG            sync.WaitGroup
+	// baseURL is the public address of the server used in emailed links.
+	baseURL              string
+	requireVerifiedEmail bool
+	// accountDeletionGrace is how long deleted accounts can be restored
+	// before they are purged; zero purges them immediately.
+	accountDeletionGrace time.Duration
+	// deletedUserChirps is database.ChirpsDelete or ChirpsAnonymize.
+	deletedUserChirps string
+	// exportDir holds data export archives until exportRetention passes.
+	exportDir       string
+	exportRetention time.Duration
+	exportWake      chan struct{}
+	mediaStore      media.Store
+	mediaMaxBytes   int64
+	// schedulerWake is signalled when a chirp is scheduled.
+	schedulerWake chan struct{}
+	janitor       janitorMetrics
 }
 
 func main() {
@@ -22,9 +57,9 @@ func main() {
 
 	godotenv.Load(".env")
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	polkaKey := os.Getenv("POLKA_KEY")
@@ -32,12 +67,101 @@ func main() {
 		log.Fatal("POLKA_KEY environment variable is not set")
 	}
 
-	db, err := database.NewDB("database.json")
+	argon2Params, err := loadArgon2Params()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dummyPasswordHash, err := auth.HashPassword("not a real password", argon2Params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passwordPolicy, err := loadPasswordPolicy()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	baseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+
+	requireVerifiedEmail := false
+	if s := os.Getenv("REQUIRE_VERIFIED_EMAIL"); s != "" {
+		requireVerifiedEmail, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Fatal("REQUIRE_VERIFIED_EMAIL must be a boolean")
+		}
+	}
+
+	refreshTokenPepper := os.Getenv("REFRESH_TOKEN_PEPPER")
+	if refreshTokenPepper == "" {
+		log.Fatal("REFRESH_TOKEN_PEPPER environment variable is not set")
+	}
+
+	db, err := database.NewDB("database.json", refreshTokenPepper)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	janitorInterval := defaultJanitorInterval
+	if s := os.Getenv("JANITOR_INTERVAL"); s != "" {
+		janitorInterval, err = time.ParseDuration(s)
+		if err != nil || janitorInterval <= 0 {
+			log.Fatal("JANITOR_INTERVAL must be a positive duration")
+		}
+	}
+
+	accountDeletionGrace := defaultAccountDeletionGrace
+	if s := os.Getenv("ACCOUNT_DELETION_GRACE"); s != "" {
+		accountDeletionGrace, err = time.ParseDuration(s)
+		if err != nil || accountDeletionGrace < 0 {
+			log.Fatal("ACCOUNT_DELETION_GRACE must be a non-negative duration")
+		}
+	}
+
+	deletedUserChirps := os.Getenv("DELETED_USER_CHIRPS")
+	if deletedUserChirps == "" {
+		deletedUserChirps = database.ChirpsDelete
+	}
+	if deletedUserChirps != database.ChirpsDelete && deletedUserChirps != database.ChirpsAnonymize {
+		log.Fatal("DELETED_USER_CHIRPS must be delete or anonymize")
+	}
+
+	// EXPORT_DIR holds export archives, which may contain everything about
+	// a user, so it must not be served by anything but the signed download
+	// endpoint
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = defaultExportDir
+	}
+
+	exportRetention := defaultExportRetention
+	if s := os.Getenv("EXPORT_RETENTION"); s != "" {
+		exportRetention, err = time.ParseDuration(s)
+		if err != nil || exportRetention <= 0 {
+			log.Fatal("EXPORT_RETENTION must be a positive duration")
+		}
+	}
+
+	// MEDIA_DIR holds uploads, including attachments of chirps not everyone
+	// may see, so it must only be served through /media/{name}
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = defaultMediaDir
+	}
+
+	mediaMaxBytes := int64(defaultMediaMaxBytes)
+	if s := os.Getenv("MEDIA_MAX_BYTES"); s != "" {
+		mediaMaxBytes, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || mediaMaxBytes <= 0 {
+			log.Fatal("MEDIA_MAX_BYTES must be a positive integer")
+		}
+	}
+
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	adminEmail := flag.String("admin", "", "Grant the admin role to the user with this email")
 	flag.Parse()
 	if dbg != nil && *dbg {
 		err := db.ResetDB()
@@ -45,33 +169,120 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	if *adminEmail != "" {
+		user, err := db.GetUserByEmail(*adminEmail)
+		if err != nil {
+			log.Fatalf("Couldn't find user %s: %s", *adminEmail, err)
+		}
+		_, err = db.SetUserRole(user.ID, database.RoleAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Granted admin role to %s", *adminEmail)
+	}
 
-	apiCfg := apiConfig{
-		fileserverHits: 0,
-		DB:             db,
-		jwtSecret:      jwtSecret,
+	apiCfg := &apiConfig{
+		fileserverHits:       0,
+		DB:                   db,
+		jwtKeys:              jwtKeys,
+		argon2Params:         argon2Params,
+		passwordPolicy:       passwordPolicy,
+		dummyPasswordHash:    dummyPasswordHash,
+		mailer:               loadMailer(),
+		baseURL:              baseURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+		accountDeletionGrace: accountDeletionGrace,
+		deletedUserChirps:    deletedUserChirps,
+		exportDir:            exportDir,
+		exportRetention:      exportRetention,
+		exportWake:           make(chan struct{}, 1),
+		schedulerWake:        make(chan struct{}, 1),
+		mediaStore:           media.Store{Dir: mediaDir},
+		mediaMaxBytes:        mediaMaxBytes,
 	}
 
 	mux := http.NewServeMux()
-	fsHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot))))
-	mux.Handle("/app/*", fsHandler)
+	fsHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(staticFileSystem{root: http.Dir(filepathRoot)})))
+	mux.Handle("/app/", fsHandler)
+
+	mux.HandleFunc("GET /media/{name}", apiCfg.handlerMediaServe)
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
 
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+
+	mux.HandleFunc("GET /api/sessions", apiCfg.middlewareAuthenticate(apiCfg.handlerSessionsList))
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.middlewareAuthenticate(apiCfg.handlerSessionsDeleteAll))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.middlewareAuthenticate(apiCfg.handlerSessionsDelete))
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PUT /api/users", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersUpdate))
+	mux.HandleFunc("POST /api/users/verify-email/request", apiCfg.middlewareAuthenticate(apiCfg.handlerVerifyEmailRequest))
+	mux.HandleFunc("POST /api/users/verify-email", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/password-reset/request", apiCfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/users/password-reset", apiCfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/users/email-change/confirm", apiCfg.handlerEmailChangeConfirm)
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.middlewareOptionalAuth(apiCfg.handlerUsersProfile))
+	mux.HandleFunc("GET /api/users/me/blocks", apiCfg.middlewareAuthenticate(apiCfg.handlerUsersBlocksList))
+	mux.HandleFunc("GET /api/users/me/mutes", apiCfg.middlewareAuthenticate(apiCfg.handlerUsersMutesList))
+	mux.HandleFunc("POST /api/users/{userID}/block", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersBlock))
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersUnblock))
+	mux.HandleFunc("POST /api/users/{userID}/mute", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersMute))
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersUnmute))
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersFollow))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersUnfollow))
+	mux.HandleFunc("GET /api/users/me/follow-requests", apiCfg.middlewareAuthenticate(apiCfg.handlerFollowRequestsList))
+	mux.HandleFunc("POST /api/users/me/follow-requests/{userID}", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerFollowRequestsApprove))
+	mux.HandleFunc("DELETE /api/users/me/follow-requests/{userID}", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerFollowersRemove))
+	mux.HandleFunc("DELETE /api/users/me/followers/{userID}", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerFollowersRemove))
+	mux.HandleFunc("PUT /api/users/me/avatar", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerAvatarUpdate))
+	mux.HandleFunc("DELETE /api/users/me/avatar", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerAvatarDelete))
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersDelete))
+	mux.HandleFunc("POST /api/users/restore", apiCfg.handlerUsersRestore)
+	mux.HandleFunc("POST /api/users/me/export", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerUsersExportCreate))
+	mux.HandleFunc("GET /api/users/me/export/{exportID}", apiCfg.middlewareAuthenticate(apiCfg.handlerUsersExportGet))
+	mux.HandleFunc("GET /api/users/me/export/{exportID}/download", apiCfg.handlerUsersExportDownload)
+	mux.HandleFunc("POST /api/users/me/mfa/totp", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/users/me/mfa/totp/confirm", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerTOTPConfirm))
+	mux.HandleFunc("DELETE /api/users/me/mfa/totp", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerTOTPDisable))
+
+	mux.HandleFunc("POST /api/conversations", apiCfg.middlewareAuthorize(auth.ScopeMessagesWrite, apiCfg.handlerConversationsCreate))
+	mux.HandleFunc("GET /api/conversations", apiCfg.middlewareAuthorize(auth.ScopeMessagesRead, apiCfg.handlerConversationsList))
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", apiCfg.middlewareAuthorize(auth.ScopeMessagesWrite, apiCfg.handlerMessagesCreate))
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", apiCfg.middlewareAuthorize(auth.ScopeMessagesRead, apiCfg.handlerMessagesList))
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", apiCfg.middlewareAuthorize(auth.ScopeMessagesWrite, apiCfg.handlerConversationsRead))
+
+	mux.HandleFunc("GET /api/notifications", apiCfg.middlewareAuthenticate(apiCfg.handlerNotificationsList))
+	mux.HandleFunc("GET /api/notifications/unread-count", apiCfg.middlewareAuthenticate(apiCfg.handlerNotificationsUnreadCount))
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.middlewareAuthenticate(apiCfg.handlerNotificationsRead))
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.middlewareAuthenticate(apiCfg.handlerNotificationPreferencesGet))
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.middlewareAuthorize(auth.ScopeUsersWrite, apiCfg.handlerNotificationPreferencesUpdate))
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
-	mux.HandleFunc("GET /api/chirps/", apiCfg.handlerChirpsRetrieve)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("POST /api/drafts", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerDraftsCreate))
+	mux.HandleFunc("GET /api/drafts", apiCfg.middlewareAuthenticate(apiCfg.handlerDraftsList))
+	mux.HandleFunc("GET /api/drafts/{draftID}", apiCfg.middlewareAuthenticate(apiCfg.handlerDraftsGet))
+	mux.HandleFunc("PUT /api/drafts/{draftID}", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerDraftsUpdate))
+	mux.HandleFunc("DELETE /api/drafts/{draftID}", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerDraftsDelete))
+	mux.HandleFunc("POST /api/drafts/{draftID}/publish", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerDraftsPublish))
+
+	mux.HandleFunc("POST /api/media", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerMediaUpload))
+	mux.HandleFunc("POST /api/chirps", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerChirpsCreate))
+	mux.HandleFunc("GET /api/chirps/", apiCfg.middlewareOptionalAuth(apiCfg.handlerChirpsRetrieve))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.middlewareOptionalAuth(apiCfg.handlerChirpsGet))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerChirpsDelete))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/poll/votes", apiCfg.middlewareAuthorize(auth.ScopeChirpsWrite, apiCfg.handlerPollVotesCreate))
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/users", apiCfg.middlewareAuthorize(auth.ScopeAdminUsers, apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.handlerAdminUsersList)))
+	mux.HandleFunc("GET /admin/lockouts", apiCfg.middlewareAuthorize(auth.ScopeAdminUsers, apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.handlerAdminLockoutsList)))
+	mux.HandleFunc("DELETE /admin/lockouts/{key}", apiCfg.middlewareAuthorize(auth.ScopeAdminUsers, apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.handlerAdminLockoutsClear)))
+	mux.HandleFunc("DELETE /admin/users/{userID}", apiCfg.middlewareAuthorize(auth.ScopeAdminUsers, apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.handlerAdminUsersDelete)))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.middlewareAuthorize(auth.ScopeAdminUsers, apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.handlerAdminUsersSetRole)))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhooks)
 
@@ -80,6 +291,46 @@ func main() {
 		Handler: mux,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	janitorDone := make(chan struct{})
+	go func() {
+		defer close(janitorDone)
+		apiCfg.runJanitor(ctx, janitorInterval)
+	}()
+
+	exportWorkerDone := make(chan struct{})
+	go func() {
+		defer close(exportWorkerDone)
+		apiCfg.runExportWorker(ctx)
+	}()
+
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		apiCfg.runScheduler(ctx)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Error shutting down server: %s", err)
+		}
+	}()
+
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	<-janitorDone
+	<-exportWorkerDone
+	<-schedulerDone
+	apiCfg.mailWG.Wait()
+	log.Println("Server stopped")
 }
@@ -14,10 +14,11 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
 	<p>Chirpy has been visited %dtimes!</p>
+	<p>Janitor has run %d times and purged %d expired refresh tokens, %d denylisted access tokens, %d login attempt records, %d used tokens, %d old notifications, %d expired chirps, %d deleted users, %d expired exports and %d orphaned images.</p>
 </body>
 
 </html>
-	`, cfg.fileserverHits)))
+	`, cfg.fileserverHits, cfg.janitor.runs.Load(), cfg.janitor.refreshTokens.Load(), cfg.janitor.revokedAccessTokens.Load(), cfg.janitor.loginAttempts.Load(), cfg.janitor.usedTokens.Load(), cfg.janitor.notifications.Load(), cfg.janitor.chirps.Load(), cfg.janitor.deletedUsers.Load(), cfg.janitor.exports.Load(), cfg.janitor.media.Load())))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
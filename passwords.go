@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/auth"
+)
+
+// loadArgon2Params reads the password hashing cost from ARGON2_MEMORY_KIB,
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM, falling back to
+// auth.DefaultArgon2Params for any that are unset. Changing them rehashes
+// each user's password on their next login.
+func loadArgon2Params() (auth.Argon2Params, error) {
+	params := auth.DefaultArgon2Params
+
+	settings := []struct {
+		env  string
+		bits int
+		set  func(uint64)
+	}{
+		{"ARGON2_MEMORY_KIB", 32, func(v uint64) { params.Memory = uint32(v) }},
+		{"ARGON2_ITERATIONS", 32, func(v uint64) { params.Iterations = uint32(v) }},
+		{"ARGON2_PARALLELISM", 8, func(v uint64) { params.Parallelism = uint8(v) }},
+	}
+	for _, setting := range settings {
+		s := os.Getenv(setting.env)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 10, setting.bits)
+		if err != nil || v == 0 {
+			return auth.Argon2Params{}, fmt.Errorf("%s must be a positive integer", setting.env)
+		}
+		setting.set(v)
+	}
+
+	return params, nil
+}
+
+// loadPasswordPolicy reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and
+// PASSWORD_ALLOW_EMAIL on top of auth.DefaultPasswordPolicy, and loads the
+// breached password list from BREACHED_PASSWORDS_FILE if it is set.
+func loadPasswordPolicy() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy
+
+	if s := os.Getenv("PASSWORD_MIN_LENGTH"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return auth.PasswordPolicy{}, fmt.Errorf("PASSWORD_MIN_LENGTH must be a non-negative integer")
+		}
+		policy.MinLength = v
+	}
+	if s := os.Getenv("PASSWORD_MAX_LENGTH"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return auth.PasswordPolicy{}, fmt.Errorf("PASSWORD_MAX_LENGTH must be a non-negative integer")
+		}
+		policy.MaxLength = v
+	}
+	if s := os.Getenv("PASSWORD_ALLOW_EMAIL"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return auth.PasswordPolicy{}, fmt.Errorf("PASSWORD_ALLOW_EMAIL must be a boolean")
+		}
+		policy.DisallowEmail = !v
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return auth.PasswordPolicy{}, fmt.Errorf("couldn't load breached passwords: %w", err)
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// respondWithPasswordViolations reports which password policy rules failed.
+func respondWithPasswordViolations(w http.ResponseWriter, violations []auth.PolicyViolation) {
+	type errorResponse struct {
+		Error      string                 `json:"error"`
+		Violations []auth.PolicyViolation `json:"violations"`
+	}
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error:      "Password does not meet the password policy",
+		Violations: violations,
+	})
+}
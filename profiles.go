@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TedMartell/ChirpyServerProject/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxWebsiteLength     = 100
+)
+
+// profileParams are the profile fields accepted when creating or updating a
+// user. Fields left out of the request are nil.
+type profileParams struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Website     *string `json:"website"`
+	Protected   *bool   `json:"protected"`
+}
+
+// validate normalizes the fields and returns them as a database update, or
+// an error suitable for the client.
+func (p profileParams) validate() (database.ProfileUpdate, error) {
+	update := database.ProfileUpdate{
+		Protected: p.Protected,
+	}
+
+	if p.Handle != nil {
+		handle := database.NormalizeHandle(*p.Handle)
+		err := database.ValidateHandle(handle)
+		if err != nil {
+			return database.ProfileUpdate{}, err
+		}
+		update.Handle = &handle
+	}
+	if p.DisplayName != nil {
+		displayName := strings.TrimSpace(*p.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			return database.ProfileUpdate{}, fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+		}
+		update.DisplayName = &displayName
+	}
+	if p.Bio != nil {
+		bio := strings.TrimSpace(*p.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return database.ProfileUpdate{}, fmt.Errorf("bio must be at most %d characters", maxBioLength)
+		}
+		update.Bio = &bio
+	}
+	if p.Website != nil {
+		website := strings.TrimSpace(*p.Website)
+		if website != "" {
+			u, err := url.Parse(website)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(website) > maxWebsiteLength {
+				return database.ProfileUpdate{}, fmt.Errorf("website must be an http or https URL of at most %d characters", maxWebsiteLength)
+			}
+		}
+		update.Website = &website
+	}
+
+	return update, nil
+}
+
+func (p profileParams) empty() bool {
+	return p.Handle == nil && p.DisplayName == nil && p.Bio == nil && p.Website == nil && p.Protected == nil
+}
+
+// authorSummary identifies a chirp's author in chirp responses.
+type authorSummary struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	protected   bool
+}
+
+// authorSummaries returns a summary of every user keyed by ID.
+func (cfg *apiConfig) authorSummaries() (map[int]*authorSummary, error) {
+	users, err := cfg.DB.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	authors := make(map[int]*authorSummary, len(users))
+	for _, user := range users {
+		author := &authorSummary{
+			ID:          user.ID,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			protected:   user.Protected,
+		}
+		if user.Avatar != nil {
+			author.AvatarURL = cfg.mediaURL(user.Avatar.ThumbnailID)
+		}
+		authors[user.ID] = author
+	}
+	return authors, nil
+}
+
+// chirpResponses converts chirps for a response to viewerID, embedding a
+// summary of each author. Chirps whose author was deleted have no author.
+func (cfg *apiConfig) chirpResponses(viewerID int, dbChirps []database.Chirp) ([]Chirp, error) {
+	authors, err := cfg.authorSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := make([]Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirp := Chirp{
+			ID:         dbChirp.ID,
+			Body:       dbChirp.Body,
+			AuthorID:   dbChirp.AuthorID,
+			Author:     authors[dbChirp.AuthorID],
+			Visibility: dbChirp.Visibility,
+			MentionIDs: dbChirp.MentionIDs,
+			PublishAt:  dbChirp.PublishAt,
+			Scheduled:  dbChirp.Scheduled,
+			ExpiresAt:  dbChirp.ExpiresAt,
+		}
+		if dbChirp.Poll != nil {
+			poll := newPollResponse(*dbChirp.Poll, viewerID)
+			chirp.Poll = &poll
+		}
+		if chirp.Visibility == "" {
+			chirp.Visibility = database.VisibilityPublic
+		}
+		// Attachments of chirps not everyone can see get URLs signed for
+		// the viewer
+		restricted := dbChirp.Scheduled ||
+			chirp.Visibility == database.VisibilityFollowers ||
+			chirp.Visibility == database.VisibilityDirect ||
+			(chirp.Author != nil && chirp.Author.protected)
+		for _, attachment := range dbChirp.Attachments {
+			if restricted {
+				chirp.Attachments = append(chirp.Attachments, cfg.restrictedMediaResponse(attachment, viewerID))
+			} else {
+				chirp.Attachments = append(chirp.Attachments, cfg.mediaResponse(attachment))
+			}
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
+
+// handlerUsersProfile returns the public profile of the user with the
+// handle. It never includes private fields such as the email address.
+func (cfg *apiConfig) handlerUsersProfile(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID          int            `json:"id"`
+		Handle      string         `json:"handle"`
+		DisplayName string         `json:"display_name,omitempty"`
+		Bio         string         `json:"bio,omitempty"`
+		Website     string         `json:"website,omitempty"`
+		Avatar      *mediaResponse `json:"avatar,omitempty"`
+		IsChirpyRed bool           `json:"is_chirpy_red"`
+		Protected   bool           `json:"protected"`
+		// ChirpCount only counts chirps the caller can see.
+		ChirpCount     int `json:"chirp_count"`
+		FollowerCount  int `json:"follower_count"`
+		FollowingCount int `json:"following_count"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	user, err := cfg.DB.GetUserByHandle(database.NormalizeHandle(r.PathValue("handle")))
+	if err == nil && user.DeletedAt != nil {
+		err = database.ErrNotExist
+	}
+	if err == nil && caller.User.ID != 0 {
+		var blocked bool
+		blocked, err = cfg.DB.IsBlocked(caller.User.ID, user.ID)
+		if err == nil && blocked {
+			err = database.ErrNotExist
+		}
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetChirpsForViewer(caller.User.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	followers, err := cfg.DB.GetFollowers(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followers")
+		return
+	}
+	following, err := cfg.DB.GetFollowing(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve follows")
+		return
+	}
+	followingCount := 0
+	for _, follow := range following {
+		if !follow.Pending {
+			followingCount++
+		}
+	}
+
+	resp := response{
+		ID:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		Website:        user.Website,
+		IsChirpyRed:    user.IsChirpyRed,
+		Protected:      user.Protected,
+		ChirpCount:     len(dbChirps),
+		FollowerCount:  len(followers),
+		FollowingCount: followingCount,
+	}
+	if user.Avatar != nil {
+		avatar := cfg.mediaResponse(*user.Avatar)
+		resp.Avatar = &avatar
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// schedulerIdleInterval caps how long the scheduler sleeps, so a clock
+	// change can't delay chirps for long.
+	schedulerIdleInterval  = time.Hour
+	schedulerRetryInterval = time.Minute
+)
+
+// wakeScheduler tells the scheduler a chirp was scheduled so it can
+// recompute when it next has to run.
+func (cfg *apiConfig) wakeScheduler() {
+	select {
+	case cfg.schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduler publishes scheduled chirps as they fall due until ctx is
+// cancelled. Scheduled chirps live in the database, so any that fell due
+// while the server was down are published as soon as it starts.
+func (cfg *apiConfig) runScheduler(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-cfg.schedulerWake:
+			timer.Stop()
+		}
+
+		published, err := cfg.DB.PublishScheduledChirps(time.Now())
+		if err != nil {
+			log.Printf("Scheduler: couldn't publish scheduled chirps: %s", err)
+		}
+		if len(published) > 0 {
+			log.Printf("Scheduler: published %d scheduled chirps", len(published))
+		}
+
+		// Retry a failed run after a while, and otherwise sleep until the
+		// next chirp is due or a new one is scheduled
+		wait := schedulerRetryInterval
+		if err == nil {
+			next, ok, err := cfg.DB.NextScheduledChirp()
+			if err != nil {
+				log.Printf("Scheduler: couldn't find the next scheduled chirp: %s", err)
+			} else if !ok {
+				wait = schedulerIdleInterval
+			} else {
+				wait = min(time.Until(next), schedulerIdleInterval)
+			}
+		}
+		timer.Reset(max(wait, 0))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// staticPages are the files and directories served under /app. The rest of
+// the working directory, which holds the database, data exports and
+// uploaded media by default, is never served.
+var staticPages = []string{
+	"/index.html",
+	"/assets",
+	"/confirm-email",
+	"/reset-password",
+	"/restore-account",
+	"/verify-email",
+}
+
+// staticFileSystem opens only staticPages, and the root directory so that
+// http.FileServer can find index.html in it.
+type staticFileSystem struct {
+	root http.FileSystem
+}
+
+func (fs staticFileSystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return fs.root.Open(name)
+	}
+	for _, page := range staticPages {
+		if name == page || strings.HasPrefix(name, page+"/") {
+			return fs.root.Open(name)
+		}
+	}
+	return nil, os.ErrNotExist
+}
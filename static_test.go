@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticFileSystem(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"index.html",
+		"assets/logo.png",
+		"verify-email/index.html",
+		"database.json",
+		".env",
+		"exports/abc.zip",
+		"media/abc.png",
+	}
+	for _, name := range files {
+		path := filepath.Join(root, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(name), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := http.StripPrefix("/app", http.FileServer(staticFileSystem{root: http.Dir(root)}))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/app/", http.StatusOK},
+		{"/app/assets/logo.png", http.StatusOK},
+		{"/app/verify-email/?token=abc", http.StatusOK},
+		{"/app/verify-email?token=abc", http.StatusMovedPermanently},
+		{"/app/database.json", http.StatusNotFound},
+		{"/app/.env", http.StatusNotFound},
+		{"/app/exports/abc.zip", http.StatusNotFound},
+		{"/app/exports/", http.StatusNotFound},
+		{"/app/media/abc.png", http.StatusNotFound},
+		{"/app/assets/../database.json", http.StatusNotFound},
+		{"/app/go.mod", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}